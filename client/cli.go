@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"paltech.config/config"
+	. "paltech.robot/robot"
+)
+
+const usage = `Usage : client <command> [flags]
+
+Commands :
+  simulate    Registers simulated robots on the server and sends their updates
+  path-test   Simulates a single robot locally and prints its static map URL at each step
+  replay      Sends the statuses recorded with "simulate -record" to the server again
+  load-test   Ramps up many virtual robots at a target update rate and reports latencies and errors
+
+Run client <command> -h to list the flags of a command
+`
+
+func addTimingFlags(flagSet *flag.FlagSet) {
+	flagSet.Float64Var(&settings.TimeMultiplier, "time-multiplier", settings.TimeMultiplier,
+		"How many times faster than real time the simulation runs")
+	flagSet.IntVar(&settings.MinUpdateIntervalSeconds, "min-update-interval", settings.MinUpdateIntervalSeconds,
+		"Minimum interval between two updates of a robot, in simulated seconds")
+	flagSet.IntVar(&settings.MaxUpdateIntervalSeconds, "max-update-interval", settings.MaxUpdateIntervalSeconds,
+		"Maximum interval between two updates of a robot, in simulated seconds")
+}
+
+func addRobotFlags(flagSet *flag.FlagSet, gardenAreaPath *string) {
+	flagSet.IntVar(&settings.WaypointsTotal, "waypoints", settings.WaypointsTotal,
+		"Number of waypoints each robot has to reach, at most the length of the pattern for lawnmower")
+	flagSet.StringVar(&settings.Motion.Pattern, "pattern", settings.Motion.Pattern,
+		"Driving pattern : "+PatternRandom+" waypoints or "+PatternLawnmower+" coverage of the garden area")
+	flagSet.Float64Var(&settings.Motion.LaneSpacingMeters, "lane-spacing", settings.Motion.LaneSpacingMeters,
+		"Distance between lawnmower lanes, in meters")
+	flagSet.Float64Var(&settings.Motion.MaxAccelerationMetersPerS2, "max-acceleration", settings.Motion.MaxAccelerationMetersPerS2,
+		"Maximum acceleration of the robots, in m/s²")
+	flagSet.Float64Var(&settings.Motion.MaxYawRateRadiansPerS, "max-yaw-rate", settings.Motion.MaxYawRateRadiansPerS,
+		"Maximum turn rate of the robots, in rad/s")
+	flagSet.Float64Var(&settings.Motion.SubStepSeconds, "sub-step", settings.Motion.SubStepSeconds,
+		"Motion integration step between two updates, in simulated seconds")
+	flagSet.Int64Var(&settings.Seed, "seed", settings.Seed,
+		"Master seed of the robots random generators, 0 uses the current time")
+	flagSet.StringVar(gardenAreaPath, "garden-area", "",
+		"JSON file describing the garden area robots move in, defaults to "+DefaultGardenArea.Name)
+}
+
+func addVirtualClockFlag(flagSet *flag.FlagSet) {
+	flagSet.BoolVar(&settings.UseVirtualClock, "virtual-clock", settings.UseVirtualClock,
+		"Runs on virtual time starting at "+VirtualClockEpoch.Format(time.RFC3339)+", without waiting between updates")
+}
+
+func validateSettings() error {
+	if settings.NRobots < 1 {
+		return errors.New("At least one robot must be simulated")
+	}
+	if settings.SpawnInterval < 0 {
+		return errors.New("Spawn interval must be positive")
+	}
+	if settings.TimeMultiplier <= 0 {
+		return errors.New("Time multiplier must be strictly positive")
+	}
+	if settings.MinUpdateIntervalSeconds < 1 || settings.MinUpdateIntervalSeconds > settings.MaxUpdateIntervalSeconds {
+		return errors.New("Update intervals must be strictly positive, and the minimum not greater than the maximum")
+	}
+	if settings.WaypointsTotal < 1 {
+		return errors.New("Robots need at least one waypoint")
+	}
+	return settings.Motion.Validate()
+}
+
+// Parses the flags of a command, then sets up the configuration and the settings
+func parseCommandFlags(flagSet *flag.FlagSet, args []string, gardenAreaPath *string) {
+	loader := config.NewLoader(flagSet)
+	// The flag set exits on parsing errors
+	flagSet.Parse(args)
+	clientConfig = loader.MustLoad()
+
+	if gardenAreaPath != nil && *gardenAreaPath != "" {
+		area, err := LoadGardenArea(*gardenAreaPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		settings.GardenArea = area
+	}
+	if err := validateSettings(); err != nil {
+		log.Fatal(err)
+	}
+
+	if settings.Seed == 0 {
+		settings.Seed = time.Now().UnixNano()
+	}
+	fmt.Println("Random seed :", settings.Seed)
+}
+
+func runSimulate(args []string) {
+	flagSet := flag.NewFlagSet("simulate", flag.ExitOnError)
+	var gardenAreaPath, recordPath string
+	flagSet.IntVar(&settings.NRobots, "robots", settings.NRobots, "Number of robots to simulate")
+	flagSet.DurationVar(&settings.SpawnInterval, "spawn-interval", settings.SpawnInterval,
+		"Real time waited between two robot registrations")
+	var faultsNameOrPath string
+	flagSet.StringVar(&recordPath, "record", "", "Records every status sent to this file, for later replay")
+	flagSet.StringVar(&faultsNameOrPath, "faults", "none",
+		"Fault profile to inject : none, flaky-network, bad-sensors, crashy, chaos or the path to a JSON profile")
+	addTimingFlags(flagSet)
+	addRobotFlags(flagSet, &gardenAreaPath)
+	addVirtualClockFlag(flagSet)
+	parseCommandFlags(flagSet, args, &gardenAreaPath)
+
+	faults, err := loadFaultProfile(faultsNameOrPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	settings.Faults = faults
+
+	if recordPath != "" {
+		if err := startRecording(recordPath); err != nil {
+			log.Fatal(err)
+		}
+		defer stopRecording()
+	}
+	simulateNRobots(settings.NRobots)
+}
+
+func runPathTest(args []string) {
+	flagSet := flag.NewFlagSet("path-test", flag.ExitOnError)
+	var gardenAreaPath string
+	addTimingFlags(flagSet)
+	addRobotFlags(flagSet, &gardenAreaPath)
+	addVirtualClockFlag(flagSet)
+	parseCommandFlags(flagSet, args, &gardenAreaPath)
+
+	if settings.UseVirtualClock {
+		testPathGeneration(NewVirtualClock(VirtualClockEpoch))
+	} else {
+		testPathGeneration(RealClock{})
+	}
+}
+
+func runReplay(args []string) {
+	flagSet := flag.NewFlagSet("replay", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage : client replay [flags] <recording file>")
+		flagSet.PrintDefaults()
+	}
+	flagSet.Float64Var(&settings.TimeMultiplier, "time-multiplier", settings.TimeMultiplier,
+		"How many times faster than real time the recording is replayed")
+	parseCommandFlags(flagSet, args, nil)
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		os.Exit(2)
+	}
+
+	if err := replayRecording(flagSet.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func validateLoadTestSettings() error {
+	if loadTestSettings.NRobots < 1 {
+		return errors.New("At least one robot must be simulated")
+	}
+	if loadTestSettings.TargetRate <= 0 {
+		return errors.New("Target rate must be strictly positive")
+	}
+	if loadTestSettings.RampUp < 0 || loadTestSettings.Duration < 0 || loadTestSettings.RequestTimeout <= 0 {
+		return errors.New("Ramp up and duration must be positive, request timeout strictly positive")
+	}
+	return nil
+}
+
+func runLoadTestCommand(args []string) {
+	flagSet := flag.NewFlagSet("load-test", flag.ExitOnError)
+	var gardenAreaPath string
+	flagSet.IntVar(&loadTestSettings.NRobots, "robots", loadTestSettings.NRobots, "Number of virtual robots")
+	flagSet.DurationVar(&loadTestSettings.RampUp, "ramp-up", loadTestSettings.RampUp,
+		"Time over which the virtual robots are started")
+	flagSet.Float64Var(&loadTestSettings.TargetRate, "rate", loadTestSettings.TargetRate,
+		"Updates per second sent by all robots together once ramped up")
+	flagSet.DurationVar(&loadTestSettings.Duration, "duration", loadTestSettings.Duration,
+		"Time the full load is held after ramping up")
+	flagSet.DurationVar(&loadTestSettings.RequestTimeout, "request-timeout", loadTestSettings.RequestTimeout,
+		"Time after which a request counts as failed")
+	flagSet.BoolVar(&loadTestSettings.SkipPathImages, "no-maps", loadTestSettings.SkipPathImages,
+		"Asks the server not to render path images, to only measure the HTTP path")
+	addRobotFlags(flagSet, &gardenAreaPath)
+	parseCommandFlags(flagSet, args, &gardenAreaPath)
+	if err := validateLoadTestSettings(); err != nil {
+		log.Fatal(err)
+	}
+
+	runLoadTest()
+}
+
+func runCommandLine(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "simulate":
+		runSimulate(args[1:])
+	case "path-test":
+		runPathTest(args[1:])
+	case "replay":
+		runReplay(args[1:])
+	case "load-test":
+		runLoadTestCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, "Unknown command "+args[0]+"\n\n"+usage)
+		os.Exit(2)
+	}
+}
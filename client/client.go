@@ -3,220 +3,284 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"math"
 	"math/rand"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
 	"time"
 
+	"paltech.config/config"
 	. "paltech.robot/robot"
 )
 
 
 
 
-type GardenArea struct {
-	Name         string
-	MinLatitude  float64
-	MaxLatitude  float64
-	MinLongitude float64
-	MaxLongitude float64
-}
-
-// https://maps.google.com/?q=<lat>,<lng>
-var gardenArea = &GardenArea{
-	Name:         "Südliche Fröttmaninger Heide",
-	MinLatitude:  48.210965,
-	MaxLatitude:  48.224528,
-	MinLongitude: 11.599042,
-	MaxLongitude: 11.614783,
-}
-
 const GlobalTimeMultiplier float64 = 10
 const MinUpdateFrequencySeconds int = 60
 const MaxUpdateFrequencySeconds int = 120
 
-const DistanceLatitudeDivider float64 = 110574
-const DistanceLongitudeDivider float64 = 111320
-const MaxDirectionChangeRadians float64 = math.Pi / 4
 const MinForwardSpeed float64 = 0.1
 const MaxForwardSpeed float64 = 3
-const WaypointReachedProbability float64 = 0.4
 const DefaultWaypointsTotal = 20
-
-var wg sync.WaitGroup
-
-
-
-func degreeToRadians(degrees float64) float64 {
-	return degrees * math.Pi / 180
-}
-
-func radiansToDegree(radians float64) float64 {
-	return radians * 180 / math.Pi
+const DefaultNRobots = 1
+const DefaultSpawnInterval = 4 * time.Second
+
+// A simulated robot stops once this many requests in a row could not reach the server
+const MaxConsecutiveRequestFailures = 5
+
+type SimulationSettings struct {
+	NRobots                  int
+	SpawnInterval            time.Duration
+	TimeMultiplier           float64
+	MinUpdateIntervalSeconds int
+	MaxUpdateIntervalSeconds int
+	WaypointsTotal           int
+	// 0 seeds from the current time
+	Seed            int64
+	UseVirtualClock bool
+	GardenArea      *GardenArea
+	Faults          *FaultProfile
+	Motion          MotionSettings
 }
 
-func distanceNorthToLatitudeChange(distanceMeters float64) float64 {
-	return distanceMeters / DistanceLatitudeDivider
+var settings = SimulationSettings{
+	NRobots:                  DefaultNRobots,
+	SpawnInterval:            DefaultSpawnInterval,
+	TimeMultiplier:           GlobalTimeMultiplier,
+	MinUpdateIntervalSeconds: MinUpdateFrequencySeconds,
+	MaxUpdateIntervalSeconds: MaxUpdateFrequencySeconds,
+	WaypointsTotal:           DefaultWaypointsTotal,
+	GardenArea:               DefaultGardenArea,
+	Faults:                   faultProfiles["none"],
+	Motion:                   defaultMotionSettings,
 }
 
-func distanceEastToLongitudeChange(distanceMeters float64, latitude float64) float64 {
-	return distanceMeters / (DistanceLongitudeDivider * math.Cos(degreeToRadians(latitude)))
-}
-
-func getHypotenuse(x float64, y float64) float64 {
-	return math.Sqrt(math.Pow(x, 2) + math.Pow(y, 2))
-}
+var wg sync.WaitGroup
+var clientConfig *config.Config
 
-func getRandomDirectionChange() float64 {
-	return randFloat64(-MaxDirectionChangeRadians, MaxDirectionChangeRadians)
-}
 
-func ensureBounds(robotStatus *RobotStatus, area *GardenArea) {
-	if robotStatus.Latitude < area.MinLatitude {
-		robotStatus.Latitude = area.MinLatitude
-		robotStatus.InvertSpeedNorth()
-	} else if robotStatus.Latitude > area.MaxLatitude {
-		robotStatus.Latitude = area.MaxLatitude
-		robotStatus.InvertSpeedNorth()
-	}
 
-	if robotStatus.Longitude < area.MinLongitude {
-		robotStatus.Longitude = area.MinLongitude
-		robotStatus.InvertSpeedEast()
-	} else if robotStatus.Longitude > area.MaxLongitude {
-		robotStatus.Longitude = area.MaxLongitude
-		robotStatus.InvertSpeedEast()
+func generateNextRobotStatus(
+	robot *Robot, newTimestamp int64, motion *MotionModel, telemetry *TelemetryModel, commands *robotCommandState,
+) *RobotStatus {
+	latestStatus := robot.GetLatestStatus()
+	var nextRobotStatus *RobotStatus
+	if telemetry.IsCharging() || commands.isPaused || commands.isAborted {
+		nextRobotStatus = motion.Hold(latestStatus, newTimestamp)
+	} else {
+		nextRobotStatus = motion.Advance(latestStatus, newTimestamp)
 	}
+	telemetry.Advance(latestStatus, nextRobotStatus)
+	commands.updateStatus(nextRobotStatus, motion)
+	return nextRobotStatus
 }
 
-func generateNextRobotStatus(robot *Robot, newTimestamp int64) *RobotStatus {
-	var nextRobotStatus RobotStatus = *robot.StatusHistory[len(robot.StatusHistory)-1]
-	elapsedSeconds := float64(newTimestamp - nextRobotStatus.Timestamp)
-	distanceNorth := nextRobotStatus.GetSpeedNorth() * elapsedSeconds
-	distanceEast := nextRobotStatus.GetSpeedEast() * elapsedSeconds
-
-	nextRobotStatus.Latitude += distanceNorthToLatitudeChange(distanceNorth)
-	nextRobotStatus.Longitude += distanceEastToLongitudeChange(distanceEast, nextRobotStatus.Latitude)
-	nextRobotStatus.DistanceCovered += getHypotenuse(distanceNorth, distanceEast)
-	if randFloat64(0, 1) <= WaypointReachedProbability {
-		nextRobotStatus.WaypointsReached += 1
-		nextRobotStatus.WaypointsSuccessful += 1
-	}
-
-	nextDirection := nextRobotStatus.GetDirectionAngleNorth() + getRandomDirectionChange()
-	nextForwardSpeed := randFloat64(MinForwardSpeed, MaxForwardSpeed)
-	nextRobotStatus.SetDirectionAndForwardSpeed(nextDirection, nextForwardSpeed)
-	ensureBounds(&nextRobotStatus, gardenArea)
-
-	nextRobotStatus.Timestamp = newTimestamp
-
-	return &nextRobotStatus
-}
-
-func getInitialStatus(timestamp int64) *RobotStatus {
+func getInitialStatus(timestamp int64, random *rand.Rand, motion *MotionModel, telemetry *TelemetryModel) *RobotStatus {
 	robotStatus := new(RobotStatus)
+	robotStatus.Version = CurrentStatusVersion
 	robotStatus.Timestamp = timestamp
-	robotStatus.Latitude = randFloat64(gardenArea.MinLatitude, gardenArea.MaxLatitude)
-	robotStatus.Longitude = randFloat64(gardenArea.MinLongitude, gardenArea.MaxLongitude)
-
-	forwardSpeed := randFloat64(MinForwardSpeed, MaxForwardSpeed)
-	direction := randFloat64(0, 2 * math.Pi)
-	robotStatus.SetDirectionAndForwardSpeed(direction, forwardSpeed)
-
-	robotStatus.WaypointsTotal = DefaultWaypointsTotal
+	robotStatus.Latitude = randFloat64(random, settings.GardenArea.MinLatitude, settings.GardenArea.MaxLatitude)
+	robotStatus.Longitude = randFloat64(random, settings.GardenArea.MinLongitude, settings.GardenArea.MaxLongitude)
+	// Robots start at rest, where they return on a return to base command
+	motion.setStatusMotion(robotStatus)
+	motion.SetBase(robotStatus.GetPosition())
+
+	robotStatus.Telemetry = telemetry.GetInitialTelemetry()
+	robotStatus.WaypointsTotal = settings.WaypointsTotal
+	if motion.GetPatternLength() > 0 && motion.GetPatternLength() < settings.WaypointsTotal {
+		robotStatus.WaypointsTotal = motion.GetPatternLength()
+	}
 	return robotStatus
 }
 
 func getCurrentTimeMultiplied(initialTime int64) int64 {
-	return initialTime + int64((float64(time.Now().Unix()) - float64(initialTime)) * GlobalTimeMultiplier)
+	return initialTime + int64((float64(time.Now().Unix()) - float64(initialTime)) * settings.TimeMultiplier)
 }
 
 
 
 
 func getStaticMapUrl(robot *Robot) string {
-	url := "https://maps.googleapis.com/maps/api/staticmap?size=1000x1000&&path=color:0xff0000ff|weight:1|"
-	url += robot.GetPath()
-	url += "&sensor=false&key=MAPSKEY"
-	return url
+	// No zoom so the map fits the path instead of the configured center
+	mapOptions := &StaticMapOptions{
+		ApiKey:     clientConfig.Maps.ApiKey,
+		SizePixels: clientConfig.Maps.SizePixels,
+	}
+	return mapOptions.GetUrl(robot.GetPath())
 }
 
-func requestCreateRobot(initialStatus *RobotStatus) int {
-	postBody, _ := json.Marshal(*initialStatus)
+// Real time to wait for robotSeconds to pass in the simulation
+func getSleepTime(robotSeconds int) time.Duration {
+	return time.Duration( float64(robotSeconds * 1000) / settings.TimeMultiplier ) * time.Millisecond
+}
+
+func requestCreateRobot(initialStatus *RobotStatus) (int, error) {
+	registration := RobotRegistration{
+		RobotStatus: *initialStatus,
+		ExpectedUpdateIntervalMilliseconds: getSleepTime(settings.MaxUpdateIntervalSeconds).Milliseconds(),
+	}
+	postBody, _ := json.Marshal(registration)
 	responseBody := bytes.NewBuffer(postBody)
-	response, err := http.Post("http://127.0.0.1:1323/register-robot", "application/json", responseBody)
+	response, err := http.Post(clientConfig.Client.ServerUrl + "/register-robot", "application/json", responseBody)
 	if err != nil {
-        log.Fatal(err)
-    }
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, errors.New("Server answered the registration with " + response.Status)
+	}
 
 	var returnedId int
-	json.NewDecoder(response.Body).Decode(&returnedId)
+	if err := json.NewDecoder(response.Body).Decode(&returnedId); err != nil {
+		return 0, err
+	}
 	fmt.Println("Created robot with id : ", returnedId)
-	return returnedId
+	return returnedId, nil
 }
 
-func requestUpdateRobot(status *RobotStatus, robotId int) {
-	postBody, _ := json.Marshal(*status)
-	url := "http://127.0.0.1:1323/update-robot/" + strconv.Itoa(robotId)
-	_, err := http.Post(url, "application/json", bytes.NewBuffer(postBody))
+// Tries again after the shortest update interval, giving up after MaxConsecutiveRequestFailures attempts
+func registerSimulatedRobot(initialStatus *RobotStatus, clock Clock) (int, error) {
+	var err error
+	for attempt := 1; attempt <= MaxConsecutiveRequestFailures; attempt++ {
+		var robotId int
+		if robotId, err = requestCreateRobot(initialStatus); err == nil {
+			return robotId, nil
+		}
+		fmt.Println("Could not register robot, attempt", attempt, "/", MaxConsecutiveRequestFailures, ":", err)
+		clock.Sleep(getSleepTime(settings.MinUpdateIntervalSeconds))
+	}
+	return 0, err
+}
+
+// Returns the commands the server sent back, if any, and whether the server accepted the update.
+// The error is only set when the server could not be reached.
+func postUpdateBody(postBody []byte, robotId int) ([]RobotCommand, bool, error) {
+	url := clientConfig.Client.ServerUrl + "/update-robot/" + strconv.Itoa(robotId)
+	response, err := http.Post(url, "application/json", bytes.NewBuffer(postBody))
 	if err != nil {
-        log.Fatal(err)
-    }
+		return nil, false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, false, nil
+	}
+	updateResponse := new(UpdateResponse)
+	if json.NewDecoder(response.Body).Decode(updateResponse) != nil {
+		return nil, true, nil
+	}
+	return updateResponse.Commands, true, nil
+}
+
+func requestUpdateRobot(status *RobotStatus, robotId int) ([]RobotCommand, bool, error) {
+	postBody, _ := json.Marshal(*status)
+	return postUpdateBody(postBody, robotId)
 }
 
 
-func simulateRobot() {
+// Each simulated robot owns its random generator and clock, so its statuses
+// only depend on the master seed and its index, whatever the other robots do
+func simulateRobot(index int, clock Clock) {
 	defer wg.Done()
 
-	currentTimestamp := time.Now().Unix()
+	random := newRobotRandom(settings.Seed, index)
+	faults := newFaultInjector(settings.Faults, index)
+	currentTimestamp := clock.Now().Unix()
 	robot := new(Robot)
-	initialStatus := getInitialStatus(currentTimestamp)
-	robot.Id = requestCreateRobot(initialStatus)
+	motion := NewMotionModel(&settings.Motion, settings.GardenArea, random)
+	telemetry := NewTelemetryModel(random)
+	initialStatus := getInitialStatus(currentTimestamp, random, motion, telemetry)
+	reportedInitialStatus := faults.corruptStatus(initialStatus)
+	robotId, err := registerSimulatedRobot(reportedInitialStatus, clock)
+	if err != nil {
+		fmt.Println("Robot", index, "could not register, stopping :", err)
+		return
+	}
+	robot.Id = robotId
 	robot.AppendStatus(initialStatus)
-	waypointsTotal := initialStatus.WaypointsTotal
+	recordStatus(robot.Id, reportedInitialStatus)
 	nIterations := 0
+	nFailedRequests := 0
+	commands := new(robotCommandState)
 
-	for robot.GetLatestStatus().WaypointsReached < waypointsTotal {
+	// The total changes when the server sets new waypoints
+	for robot.GetLatestStatus().WaypointsReached < robot.GetLatestStatus().WaypointsTotal && !commands.hasAbortedMission() {
 		fmt.Println(
 			"Robot", robot.Id, 
-			"waypoints reached : ", robot.GetLatestStatus().WaypointsReached, "/", waypointsTotal,
+			"waypoints reached : ", robot.GetLatestStatus().WaypointsReached, "/", robot.GetLatestStatus().WaypointsTotal,
 			"on", nIterations, "iterations",
 		)
-		nextUpdateDelaySeconds := randInt(MinUpdateFrequencySeconds, MaxUpdateFrequencySeconds)
-		sleepTime := time.Duration( float64(nextUpdateDelaySeconds * 1000) / GlobalTimeMultiplier ) * time.Millisecond
+		nextUpdateDelaySeconds := commands.getNextUpdateDelaySeconds(
+			randInt(random, settings.MinUpdateIntervalSeconds, settings.MaxUpdateIntervalSeconds),
+		)
+		sleepTime := getSleepTime(nextUpdateDelaySeconds)
 		fmt.Println("Sleep time : ", sleepTime)
-		time.Sleep(sleepTime)
+		clock.Sleep(sleepTime)
 		
 		currentTimestamp += int64(nextUpdateDelaySeconds)
-		nextRobotStatus := generateNextRobotStatus(robot, currentTimestamp)
-		requestUpdateRobot(nextRobotStatus, robot.Id)
+		nextRobotStatus := generateNextRobotStatus(robot, currentTimestamp, motion, telemetry, commands)
+		registeredId := robot.Id
+		receivedCommands, isReceived, err := faults.sendUpdate(robot, nextRobotStatus)
+		if err != nil {
+			// The robot keeps driving, its next status is the retry
+			nFailedRequests++
+			fmt.Println("Robot", robot.Id, "could not reach the server,", nFailedRequests, "failures in a row :", err)
+			if nFailedRequests >= MaxConsecutiveRequestFailures {
+				fmt.Println("Robot", robot.Id, "stopping, the server is unreachable")
+				return
+			}
+		} else {
+			nFailedRequests = 0
+		}
+		if isReceived {
+			commands.confirmAcknowledgements(nextRobotStatus.Acknowledgements)
+		} else if robot.Id != registeredId {
+			// The server expires the commands of the previous id that were never acknowledged
+			commands.acknowledgements = nil
+		}
 		robot.StatusHistory = append(robot.StatusHistory, nextRobotStatus)
+		commands.apply(receivedCommands, robot, motion)
 		nIterations++
 	}
+	if commands.isAborted {
+		fmt.Println("Robot", robot.Id, "aborted its mission")
+	}
 }
 
 func simulateNRobots(n int) {
 	wg.Add(n)
 
 	for i := 0 ; i < n ; i++ {
-		go simulateRobot()
-		time.Sleep(4 * time.Second)
+		if settings.UseVirtualClock {
+			// Robots start at the same virtual times as with a real clock, without waiting for each other
+			clock := NewVirtualClock(VirtualClockEpoch.Add(time.Duration(i) * settings.SpawnInterval))
+			go simulateRobot(i, clock)
+		} else {
+			go simulateRobot(i, RealClock{})
+			time.Sleep(settings.SpawnInterval)
+		}
 	}
 
 	wg.Wait()
 }
 
-func testPathGeneration() {
-	currentTimestamp := time.Now().Unix()
+// Returns the simulated robot, so that seeded runs can be compared
+func testPathGeneration(clock Clock) *Robot {
+	random := newRobotRandom(settings.Seed, 0)
+	currentTimestamp := clock.Now().Unix()
 	robot := new(Robot)
-	initialStatus := getInitialStatus(currentTimestamp)
+	motion := NewMotionModel(&settings.Motion, settings.GardenArea, random)
+	telemetry := NewTelemetryModel(random)
+	initialStatus := getInitialStatus(currentTimestamp, random, motion, telemetry)
 	robot.AppendStatus(initialStatus)
 	waypointsTotal := initialStatus.WaypointsTotal
 	nIterations := 0
+	// No server to send commands
+	commands := new(robotCommandState)
 
 	for robot.GetLatestStatus().WaypointsReached < waypointsTotal {
 		fmt.Println(
@@ -224,36 +288,46 @@ func testPathGeneration() {
 			robot.GetLatestStatus().WaypointsReached, "/", waypointsTotal, "waypoints reached, ",
 			nIterations, "iterations",
 		)
-		nextUpdateDelaySeconds := randInt(MinUpdateFrequencySeconds, MaxUpdateFrequencySeconds)
-		sleepTime := time.Duration( float64(nextUpdateDelaySeconds * 1000) / GlobalTimeMultiplier ) * time.Millisecond
+		nextUpdateDelaySeconds := randInt(random, settings.MinUpdateIntervalSeconds, settings.MaxUpdateIntervalSeconds)
+		sleepTime := getSleepTime(nextUpdateDelaySeconds)
 		fmt.Println("Next delay : ", nextUpdateDelaySeconds, "s")
 		fmt.Println("Sleep time : ", sleepTime)
-		time.Sleep(sleepTime)
+		clock.Sleep(sleepTime)
 		
 		fmt.Println("\n============================================================================")
 		currentTimestamp += int64(nextUpdateDelaySeconds)
-		nextRobotStatus := generateNextRobotStatus(robot, currentTimestamp)
+		nextRobotStatus := generateNextRobotStatus(robot, currentTimestamp, motion, telemetry, commands)
 		robot.StatusHistory = append(robot.StatusHistory, nextRobotStatus)
 		fmt.Println("Static map url : ", getStaticMapUrl(robot))
 		nIterations++
 	}
 
 	// robot.GenerateAndSavePathImage()
+	return robot
 }
 
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
+	runCommandLine(os.Args[1:])
+}
+
+// Mixes the master seed with the robot index (splitmix64 finalizer) so neighbouring
+// robots get unrelated random streams
+func deriveRobotSeed(masterSeed int64, index int) int64 {
+	z := uint64(masterSeed) + uint64(index + 1) * 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return int64(z ^ (z >> 31))
+}
 
-	// testPathGeneration()
-	// simulateRobot()
-	simulateNRobots(1)
+func newRobotRandom(masterSeed int64, index int) *rand.Rand {
+	return rand.New(rand.NewSource(deriveRobotSeed(masterSeed, index)))
 }
 
-func randFloat64(min float64, max float64) float64 {
-	return min + rand.Float64() * (max - min)
+func randFloat64(random *rand.Rand, min float64, max float64) float64 {
+	return min + random.Float64() * (max - min)
 }
 
-func randInt(min int, max int) int {
-	return min + rand.Intn(max - min + 1)
+func randInt(random *rand.Rand, min int, max int) int {
+	return min + random.Intn(max - min + 1)
 }
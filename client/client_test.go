@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"paltech.config/config"
+	. "paltech.robot/robot"
+)
+
+// Runs with the default settings and the given seed on virtual time, restoring the settings afterwards
+func withSeed(t *testing.T, seed int64) {
+	previousSettings, previousConfig := settings, clientConfig
+	t.Cleanup(func() {
+		settings, clientConfig = previousSettings, previousConfig
+	})
+	settings.Seed = seed
+	settings.UseVirtualClock = true
+	settings.WaypointsTotal = 5
+	clientConfig = &config.Config{}
+}
+
+func getPathTestStatuses(t *testing.T, seed int64) []RobotStatus {
+	withSeed(t, seed)
+	robot := testPathGeneration(NewVirtualClock(VirtualClockEpoch))
+	statuses := make([]RobotStatus, 0, len(robot.StatusHistory))
+	for _, status := range robot.StatusHistory {
+		statuses = append(statuses, *status)
+	}
+	return statuses
+}
+
+// Server giving ids in registration order and recording the body of every update
+type recordingServer struct {
+	*httptest.Server
+	nextRobotId int
+	updates     map[int][]string
+	mutex       sync.Mutex
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	server := &recordingServer{updates: make(map[int][]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register-robot", func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		json.NewEncoder(w).Encode(server.nextRobotId)
+		server.nextRobotId++
+	})
+	mux.HandleFunc("/update-robot/", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/update-robot/"))
+		body, _ := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		server.mutex.Lock()
+		server.updates[id] = append(server.updates[id], string(body))
+		server.mutex.Unlock()
+		json.NewEncoder(w).Encode(UpdateResponse{})
+	})
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Updates sent by each robot. Robots register concurrently and may get other ids from one run to the next,
+// so the sequences are sorted instead of being keyed by id.
+func (server *recordingServer) getUpdateSequences() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	sequences := make([]string, 0, len(server.updates))
+	for _, updates := range server.updates {
+		sequences = append(sequences, strings.Join(updates, ""))
+	}
+	sort.Strings(sequences)
+	return sequences
+}
+
+func getSimulatedUpdates(t *testing.T, seed int64, nRobots int) []string {
+	withSeed(t, seed)
+	settings.SpawnInterval = time.Minute
+	server := newRecordingServer(t)
+	clientConfig.Client.ServerUrl = server.URL
+	if nRobots == 1 {
+		wg.Add(1)
+		simulateRobot(0, NewVirtualClock(VirtualClockEpoch))
+	} else {
+		simulateNRobots(nRobots)
+	}
+	return server.getUpdateSequences()
+}
+
+func TestPathGenerationIsReproducible(t *testing.T) {
+	first := getPathTestStatuses(t, 42)
+	second := getPathTestStatuses(t, 42)
+	if len(first) < 2 {
+		t.Fatalf("Expected several statuses, got %d", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("Two runs with the same seed generated different statuses")
+	}
+	if reflect.DeepEqual(first, getPathTestStatuses(t, 43)) {
+		t.Fatal("Runs with different seeds generated the same statuses")
+	}
+}
+
+func TestSimulatedRobotIsReproducible(t *testing.T) {
+	first := getSimulatedUpdates(t, 42, 1)
+	second := getSimulatedUpdates(t, 42, 1)
+	if len(first) != 1 || first[0] == "" {
+		t.Fatalf("Expected the updates of one robot, got %d sequences", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("Two runs with the same seed sent different updates")
+	}
+	if reflect.DeepEqual(first, getSimulatedUpdates(t, 43, 1)) {
+		t.Fatal("Runs with different seeds sent the same updates")
+	}
+}
+
+func TestSimulatedFleetIsReproducible(t *testing.T) {
+	first := getSimulatedUpdates(t, 7, 3)
+	second := getSimulatedUpdates(t, 7, 3)
+	if len(first) != 3 {
+		t.Fatalf("Expected the updates of 3 robots, got %d sequences", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("Two runs with the same seed sent different updates")
+	}
+	if reflect.DeepEqual(first, getSimulatedUpdates(t, 8, 3)) {
+		t.Fatal("Runs with different seeds sent the same updates")
+	}
+}
+
+// Robots stop instead of exiting the client when the server can not be reached
+func TestSimulatedRobotStopsWhenServerIsUnreachable(t *testing.T) {
+	withSeed(t, 42)
+	server := newRecordingServer(t)
+	clientConfig.Client.ServerUrl = server.URL
+	server.Close()
+
+	wg.Add(1)
+	simulateRobot(0, NewVirtualClock(VirtualClockEpoch))
+	if len(server.getUpdateSequences()) != 0 {
+		t.Fatal("Expected no update to reach the closed server")
+	}
+}
+
+// Updates failing in a row stop the robot once MaxConsecutiveRequestFailures is reached, instead of exiting the client
+func TestSimulatedRobotStopsAfterFailedUpdates(t *testing.T) {
+	withSeed(t, 42)
+	settings.WaypointsTotal = 1000
+	// Incremented by the server goroutines
+	var nUpdates atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/register-robot" {
+			json.NewEncoder(w).Encode(0)
+			return
+		}
+		nUpdates.Add(1)
+		// Drops the connection without answering, like a server going down
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	t.Cleanup(server.Close)
+	clientConfig.Client.ServerUrl = server.URL
+
+	wg.Add(1)
+	simulateRobot(0, NewVirtualClock(VirtualClockEpoch))
+	if n := int(nUpdates.Load()); n != MaxConsecutiveRequestFailures {
+		t.Fatalf("Expected the robot to stop after %d failed updates, it sent %d", MaxConsecutiveRequestFailures, n)
+	}
+}
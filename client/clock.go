@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Start of the simulation when running on a virtual clock, fixed so that runs are reproducible
+var VirtualClockEpoch = time.Date(2023, time.January, 1, 8, 0, 0, 0, time.UTC)
+
+// Source of time of a simulated robot, so a simulation can run instantly on virtual time
+type Clock interface {
+	Now() time.Time
+	Sleep(duration time.Duration)
+}
+
+type RealClock struct{}
+
+func (clock RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (clock RealClock) Sleep(duration time.Duration) {
+	time.Sleep(duration)
+}
+
+// Sleeping only moves the clock forward, without waiting
+type VirtualClock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (clock *VirtualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *VirtualClock) Sleep(duration time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	if duration > 0 {
+		clock.now = clock.now.Add(duration)
+	}
+}
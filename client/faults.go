@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+
+	. "paltech.robot/robot"
+	"paltech.robot/robot/geo"
+)
+
+// Salted into the master seed so fault decisions never consume the motion random stream :
+// the same seed gives the same trajectories with or without faults
+const faultSeedSalt int64 = 0x5FA17
+
+// Probabilities apply to each update, durations are in simulated seconds
+type FaultProfile struct {
+	DropoutProbability float64 `json:"dropout_probability"`
+	MinDropoutSeconds  int     `json:"min_dropout_seconds"`
+	MaxDropoutSeconds  int     `json:"max_dropout_seconds"`
+
+	// Delayed updates are delivered after newer ones, hence out of order
+	DelayProbability float64 `json:"delay_probability"`
+	MinDelaySeconds  int     `json:"min_delay_seconds"`
+	MaxDelaySeconds  int     `json:"max_delay_seconds"`
+
+	DuplicateProbability     float64 `json:"duplicate_probability"`
+	MalformedJSONProbability float64 `json:"malformed_json_probability"`
+
+	GPSNoiseMeters     float64 `json:"gps_noise_meters"`
+	GPSJumpProbability float64 `json:"gps_jump_probability"`
+	GPSJumpMeters      float64 `json:"gps_jump_meters"`
+	// Relative error of the reported distance covered, 0.1 reports 10% more than driven
+	OdometerDriftRatio float64 `json:"odometer_drift_ratio"`
+
+	// A crashed robot stays silent for the downtime, then registers again as a new robot
+	CrashProbability        float64 `json:"crash_probability"`
+	MinCrashDowntimeSeconds int     `json:"min_crash_downtime_seconds"`
+	MaxCrashDowntimeSeconds int     `json:"max_crash_downtime_seconds"`
+}
+
+var faultProfiles = map[string]*FaultProfile{
+	"none": {},
+	"flaky-network": {
+		DropoutProbability:   0.05,
+		MinDropoutSeconds:    60,
+		MaxDropoutSeconds:    600,
+		DelayProbability:     0.1,
+		MinDelaySeconds:      30,
+		MaxDelaySeconds:      300,
+		DuplicateProbability: 0.05,
+	},
+	"bad-sensors": {
+		GPSNoiseMeters:     1.5,
+		GPSJumpProbability: 0.03,
+		GPSJumpMeters:      200,
+		OdometerDriftRatio: 0.1,
+	},
+	"crashy": {
+		MalformedJSONProbability: 0.03,
+		CrashProbability:         0.03,
+		MinCrashDowntimeSeconds:  120,
+		MaxCrashDowntimeSeconds:  900,
+	},
+	"chaos": {
+		DropoutProbability:       0.05,
+		MinDropoutSeconds:        60,
+		MaxDropoutSeconds:        600,
+		DelayProbability:         0.1,
+		MinDelaySeconds:          30,
+		MaxDelaySeconds:          300,
+		DuplicateProbability:     0.05,
+		MalformedJSONProbability: 0.03,
+		GPSNoiseMeters:           1.5,
+		GPSJumpProbability:       0.03,
+		GPSJumpMeters:            200,
+		OdometerDriftRatio:       0.1,
+		CrashProbability:         0.03,
+		MinCrashDowntimeSeconds:  120,
+		MaxCrashDowntimeSeconds:  900,
+	},
+}
+
+func (profile *FaultProfile) Validate() error {
+	probabilities := []float64{
+		profile.DropoutProbability, profile.DelayProbability, profile.DuplicateProbability,
+		profile.MalformedJSONProbability, profile.GPSJumpProbability, profile.CrashProbability,
+	}
+	for _, probability := range probabilities {
+		if probability < 0 || probability > 1 {
+			return errors.New("Fault probabilities must be between 0 and 1")
+		}
+	}
+	if profile.MinDropoutSeconds < 0 || profile.MinDropoutSeconds > profile.MaxDropoutSeconds ||
+		profile.MinDelaySeconds < 0 || profile.MinDelaySeconds > profile.MaxDelaySeconds ||
+		profile.MinCrashDowntimeSeconds < 0 || profile.MinCrashDowntimeSeconds > profile.MaxCrashDowntimeSeconds {
+		return errors.New("Fault durations must be positive, and minimums not greater than maximums")
+	}
+	if profile.GPSNoiseMeters < 0 || profile.GPSJumpMeters < 0 || profile.OdometerDriftRatio <= -1 {
+		return errors.New("GPS noise and jumps must be positive, and odometer drift greater than -1")
+	}
+	return nil
+}
+
+// Accepts the name of a built-in profile or the path to a JSON file
+func loadFaultProfile(nameOrPath string) (*FaultProfile, error) {
+	if profile, isBuiltIn := faultProfiles[nameOrPath]; isBuiltIn {
+		return profile, nil
+	}
+
+	file, err := os.Open(nameOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a built-in fault profile nor a readable file : %w", nameOrPath, err)
+	}
+	defer file.Close()
+
+	profile := new(FaultProfile)
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(profile); err != nil {
+		return nil, fmt.Errorf("Could not parse fault profile %s : %w", nameOrPath, err)
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+type pendingUpdate struct {
+	status             *RobotStatus
+	deliverAtTimestamp int64
+}
+
+// Sits between a simulated robot and the server, corrupting and disrupting what reaches the server.
+// The simulated robot keeps its true status history, only the reported statuses are altered.
+type faultInjector struct {
+	profile *FaultProfile
+	random  *rand.Rand
+	// Robot timestamps until which nothing reaches the server
+	offlineUntilTimestamp int64
+	crashedUntilTimestamp int64
+	pendingUpdates        []pendingUpdate
+}
+
+func newFaultInjector(profile *FaultProfile, index int) *faultInjector {
+	return &faultInjector{
+		profile: profile,
+		random:  newRobotRandom(settings.Seed^faultSeedSalt, index),
+	}
+}
+
+func (injector *faultInjector) happens(probability float64) bool {
+	return probability > 0 && injector.random.Float64() < probability
+}
+
+// Returns the status as the faulty sensors report it
+func (injector *faultInjector) corruptStatus(status *RobotStatus) *RobotStatus {
+	reportedStatus := *status
+	profile := injector.profile
+
+	if profile.GPSNoiseMeters > 0 {
+		noiseEast := injector.random.NormFloat64() * profile.GPSNoiseMeters
+		noiseNorth := injector.random.NormFloat64() * profile.GPSNoiseMeters
+		reportedStatus.SetPosition(geo.FromENU(reportedStatus.GetPosition(), noiseEast, noiseNorth))
+	}
+	if injector.happens(profile.GPSJumpProbability) {
+		jumpBearing := randFloat64(injector.random, 0, 360)
+		reportedStatus.SetPosition(geo.DestinationPoint(reportedStatus.GetPosition(), jumpBearing, profile.GPSJumpMeters))
+		fmt.Println("Fault : GPS jump of", profile.GPSJumpMeters, "m")
+	}
+	reportedStatus.DistanceCovered *= 1 + profile.OdometerDriftRatio
+	return &reportedStatus
+}
+
+// Returns the commands received in response and whether the server accepted the status,
+// or the error when the server could not be reached
+func (injector *faultInjector) postUpdate(status *RobotStatus, robotId int) ([]RobotCommand, bool, error) {
+	if injector.happens(injector.profile.MalformedJSONProbability) {
+		postBody, _ := json.Marshal(*status)
+		fmt.Println("Fault : sending malformed JSON for robot", robotId)
+		return postUpdateBody(postBody[:len(postBody)/2], robotId)
+	}
+
+	commands, isReceived, err := requestUpdateRobot(status, robotId)
+	if err != nil {
+		return nil, false, err
+	}
+	recordStatus(robotId, status)
+	if injector.happens(injector.profile.DuplicateProbability) {
+		fmt.Println("Fault : sending duplicate update for robot", robotId)
+		// The first update went through, a lost duplicate changes nothing
+		if duplicateCommands, isDuplicateReceived, err := requestUpdateRobot(status, robotId); err == nil {
+			commands = append(commands, duplicateCommands...)
+			isReceived = isReceived || isDuplicateReceived
+		}
+	}
+	return commands, isReceived, nil
+}
+
+// Updates that could not reach the server stay pending and are sent again with the next update
+func (injector *faultInjector) flushPendingUpdates(currentTimestamp int64, robotId int) ([]RobotCommand, error) {
+	commands := make([]RobotCommand, 0)
+	stillPending := make([]pendingUpdate, 0, len(injector.pendingUpdates))
+	var lastErr error
+	for _, pending := range injector.pendingUpdates {
+		if pending.deliverAtTimestamp <= currentTimestamp && lastErr == nil {
+			fmt.Println("Fault : delivering delayed update of", pending.status.Timestamp, "for robot", robotId)
+			// The acknowledgements of a delayed status are also in the following ones
+			delayedCommands, _, err := injector.postUpdate(pending.status, robotId)
+			if err == nil {
+				commands = append(commands, delayedCommands...)
+				continue
+			}
+			lastErr = err
+		}
+		stillPending = append(stillPending, pending)
+	}
+	injector.pendingUpdates = stillPending
+	return commands, lastErr
+}
+
+// Sends the status of the robot to the server through the faults of the profile, returning the commands received
+// and whether the server accepted the status right away, or the error when the server could not be reached.
+// May register the robot again after a crash, changing its id.
+func (injector *faultInjector) sendUpdate(robot *Robot, status *RobotStatus) ([]RobotCommand, bool, error) {
+	profile := injector.profile
+	timestamp := status.Timestamp
+
+	if timestamp < injector.crashedUntilTimestamp {
+		return nil, false, nil
+	}
+	if injector.crashedUntilTimestamp > 0 {
+		fmt.Println("Fault : robot", robot.Id, "restarted after crash")
+		reportedStatus := injector.corruptStatus(status)
+		robotId, err := requestCreateRobot(reportedStatus)
+		if err != nil {
+			// Still restarting, registers with the next update
+			return nil, false, err
+		}
+		injector.crashedUntilTimestamp = 0
+		robot.Id = robotId
+		recordStatus(robot.Id, reportedStatus)
+		return nil, false, nil
+	}
+	if injector.happens(profile.CrashProbability) {
+		downtime := randInt(injector.random, profile.MinCrashDowntimeSeconds, profile.MaxCrashDowntimeSeconds)
+		injector.crashedUntilTimestamp = timestamp + int64(math.Max(float64(downtime), 1))
+		injector.pendingUpdates = nil
+		fmt.Println("Fault : robot", robot.Id, "crashed for", downtime, "s")
+		return nil, false, nil
+	}
+
+	if timestamp < injector.offlineUntilTimestamp {
+		return nil, false, nil
+	}
+	if injector.happens(profile.DropoutProbability) {
+		duration := randInt(injector.random, profile.MinDropoutSeconds, profile.MaxDropoutSeconds)
+		injector.offlineUntilTimestamp = timestamp + int64(duration)
+		fmt.Println("Fault : network of robot", robot.Id, "dropped for", duration, "s")
+		return nil, false, nil
+	}
+
+	reportedStatus := injector.corruptStatus(status)
+	if injector.happens(profile.DelayProbability) {
+		delay := randInt(injector.random, profile.MinDelaySeconds, profile.MaxDelaySeconds)
+		injector.pendingUpdates = append(
+			injector.pendingUpdates,
+			pendingUpdate{status: reportedStatus, deliverAtTimestamp: timestamp + int64(delay)},
+		)
+		fmt.Println("Fault : delaying update of robot", robot.Id, "by", delay, "s")
+		commands, err := injector.flushPendingUpdates(timestamp, robot.Id)
+		return commands, false, err
+	}
+	commands, isReceived, err := injector.postUpdate(reportedStatus, robot.Id)
+	if err != nil {
+		return nil, false, err
+	}
+	delayedCommands, err := injector.flushPendingUpdates(timestamp, robot.Id)
+	return append(commands, delayedCommands...), isReceived, err
+}
@@ -4,4 +4,11 @@ go 1.20
 
 replace paltech.robot/robot => ../robot
 
-require paltech.robot/robot v0.0.0-00010101000000-000000000000
+replace paltech.config/config => ../config
+
+require (
+	paltech.config/config v0.0.0-00010101000000-000000000000
+	paltech.robot/robot v0.0.0-00010101000000-000000000000
+)
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	. "paltech.robot/robot"
+)
+
+type LoadTestSettings struct {
+	NRobots int
+	// Time over which the robots are started, evenly spread
+	RampUp time.Duration
+	// Updates per second sent by the whole fleet once ramped up
+	TargetRate     float64
+	Duration       time.Duration
+	RequestTimeout time.Duration
+	SkipPathImages bool
+}
+
+const DefaultLoadTestNRobots = 100
+const DefaultLoadTestRampUp = 10 * time.Second
+const DefaultLoadTestTargetRate float64 = 50
+const DefaultLoadTestDuration = 30 * time.Second
+const DefaultLoadTestRequestTimeout = 5 * time.Second
+
+var loadTestSettings = LoadTestSettings{
+	NRobots:        DefaultLoadTestNRobots,
+	RampUp:         DefaultLoadTestRampUp,
+	TargetRate:     DefaultLoadTestTargetRate,
+	Duration:       DefaultLoadTestDuration,
+	RequestTimeout: DefaultLoadTestRequestTimeout,
+}
+
+// Mirrors the /stats response of the server
+type ServerStats struct {
+	UptimeSeconds       float64 `json:"uptime_seconds"`
+	RobotsRegistered    int64   `json:"robots_registered"`
+	UpdatesReceived     int64   `json:"updates_received"`
+	UpdatesRejected     int64   `json:"updates_rejected"`
+	PathImagesGenerated int64   `json:"path_images_generated"`
+}
+
+type requestKindStats struct {
+	latencies    []time.Duration
+	errorsByKind map[string]int
+}
+
+// Collects the outcome of every request sent by the virtual robots
+type loadTestStats struct {
+	mutex  sync.Mutex
+	byKind map[string]*requestKindStats
+}
+
+func newLoadTestStats() *loadTestStats {
+	return &loadTestStats{byKind: make(map[string]*requestKindStats)}
+}
+
+func (stats *loadTestStats) add(requestKind string, latency time.Duration, err error) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	kindStats, isKnown := stats.byKind[requestKind]
+	if !isKnown {
+		kindStats = &requestKindStats{errorsByKind: make(map[string]int)}
+		stats.byKind[requestKind] = kindStats
+	}
+	if err != nil {
+		kindStats.errorsByKind[err.Error()]++
+		return
+	}
+	kindStats.latencies = append(kindStats.latencies, latency)
+}
+
+func getPercentile(sortedLatencies []time.Duration, percentile float64) time.Duration {
+	if len(sortedLatencies) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(percentile/100*float64(len(sortedLatencies)))) - 1
+	return sortedLatencies[int(math.Max(float64(index), 0))]
+}
+
+func (stats *loadTestStats) print(elapsed time.Duration) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	kinds := make([]string, 0, len(stats.byKind))
+	for kind := range stats.byKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		kindStats := stats.byKind[kind]
+		nErrors := 0
+		for _, count := range kindStats.errorsByKind {
+			nErrors += count
+		}
+		nRequests := len(kindStats.latencies) + nErrors
+		latencies := append([]time.Duration(nil), kindStats.latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		fmt.Println("\n" + kind + " requests :")
+		fmt.Printf(" - Sent : %d (%.1f/s)\n", nRequests, float64(nRequests)/elapsed.Seconds())
+		fmt.Printf(" - Errors : %d (%.2f%%)\n", nErrors, 100*float64(nErrors)/math.Max(float64(nRequests), 1))
+		for message, count := range kindStats.errorsByKind {
+			fmt.Printf("     %d x %s\n", count, message)
+		}
+		fmt.Println(
+			" - Latency : p50", getPercentile(latencies, 50),
+			"p90", getPercentile(latencies, 90),
+			"p99", getPercentile(latencies, 99),
+			"max", getPercentile(latencies, 100),
+		)
+	}
+}
+
+var loadTestHttpClient *http.Client
+
+func newLoadTestHttpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Keeps one connection per robot alive instead of reopening them for each request
+	transport.MaxIdleConns = loadTestSettings.NRobots
+	transport.MaxIdleConnsPerHost = loadTestSettings.NRobots
+	return &http.Client{Transport: transport, Timeout: loadTestSettings.RequestTimeout}
+}
+
+func postJsonForLoadTest(url string, body interface{}, response interface{}) error {
+	postBody, _ := json.Marshal(body)
+	httpResponse, err := loadTestHttpClient.Post(url, "application/json", bytes.NewBuffer(postBody))
+	if err != nil {
+		var timeoutError interface{ Timeout() bool }
+		if errors.As(err, &timeoutError) && timeoutError.Timeout() {
+			return errors.New("timeout")
+		}
+		return errors.New("connection error")
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return errors.New("HTTP " + strconv.Itoa(httpResponse.StatusCode))
+	}
+	if response != nil {
+		return json.NewDecoder(httpResponse.Body).Decode(response)
+	}
+	return nil
+}
+
+func fetchServerStats() (*ServerStats, error) {
+	response, err := loadTestHttpClient.Get(clientConfig.Client.ServerUrl + "/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("Server stats returned HTTP " + strconv.Itoa(response.StatusCode))
+	}
+	stats := new(ServerStats)
+	return stats, json.NewDecoder(response.Body).Decode(stats)
+}
+
+// A robot sending updates at a fixed rate, as fast as the load test needs, whatever its simulated pace
+func runVirtualRobot(index int, startDelay time.Duration, updateInterval time.Duration, stopTime time.Time, stats *loadTestStats) {
+	defer wg.Done()
+	time.Sleep(startDelay)
+
+	random := newRobotRandom(settings.Seed, index)
+	currentTimestamp := time.Now().Unix()
+	robot := new(Robot)
+	motion := NewMotionModel(&settings.Motion, settings.GardenArea, random)
+	telemetry := NewTelemetryModel(random)
+	// Load tests do not send commands
+	commands := new(robotCommandState)
+	robot.AppendStatus(getInitialStatus(currentTimestamp, random, motion, telemetry))
+
+	registration := RobotRegistration{
+		RobotStatus:                        *robot.GetLatestStatus(),
+		ExpectedUpdateIntervalMilliseconds: updateInterval.Milliseconds(),
+	}
+	requestStart := time.Now()
+	err := postJsonForLoadTest(clientConfig.Client.ServerUrl+"/register-robot", registration, &robot.Id)
+	stats.add("register", time.Since(requestStart), err)
+	if err != nil {
+		return
+	}
+
+	updateUrl := clientConfig.Client.ServerUrl + "/update-robot/" + strconv.Itoa(robot.Id)
+	if loadTestSettings.SkipPathImages {
+		updateUrl += "?skip_path_image=true"
+	}
+	// Random phase so robots started together do not send in bursts
+	time.Sleep(time.Duration(random.Int63n(int64(updateInterval))))
+	ticker := time.NewTicker(updateInterval)
+	defer ticker.Stop()
+
+	for time.Now().Before(stopTime) {
+		currentTimestamp += int64(randInt(random, settings.MinUpdateIntervalSeconds, settings.MaxUpdateIntervalSeconds))
+		nextStatus := generateNextRobotStatus(robot, currentTimestamp, motion, telemetry, commands)
+		// Only the latest status is needed to generate the next one
+		robot.StatusHistory = []*RobotStatus{nextStatus}
+
+		requestStart := time.Now()
+		err := postJsonForLoadTest(updateUrl, nextStatus, nil)
+		stats.add("update", time.Since(requestStart), err)
+		<-ticker.C
+	}
+}
+
+func runLoadTest() {
+	loadTestHttpClient = newLoadTestHttpClient()
+	stats := newLoadTestStats()
+	updateInterval := time.Duration(float64(loadTestSettings.NRobots) / loadTestSettings.TargetRate * float64(time.Second))
+	spawnInterval := loadTestSettings.RampUp / time.Duration(loadTestSettings.NRobots)
+
+	serverStatsBefore, err := fetchServerStats()
+	if err != nil {
+		fmt.Println("Could not fetch server stats, server side throughput will not be reported :", err)
+	}
+
+	fmt.Println(
+		"Ramping up to", loadTestSettings.NRobots, "robots in", loadTestSettings.RampUp,
+		"each sending an update every", updateInterval, "then holding for", loadTestSettings.Duration,
+	)
+	startTime := time.Now()
+	stopTime := startTime.Add(loadTestSettings.RampUp + loadTestSettings.Duration)
+	wg.Add(loadTestSettings.NRobots)
+	for i := 0; i < loadTestSettings.NRobots; i++ {
+		go runVirtualRobot(i, time.Duration(i)*spawnInterval, updateInterval, stopTime, stats)
+	}
+	wg.Wait()
+	elapsed := time.Since(startTime)
+
+	fmt.Println("\n============================================================================")
+	fmt.Println("Load test finished in", elapsed.Round(time.Millisecond))
+	stats.print(elapsed)
+
+	if serverStatsBefore == nil {
+		return
+	}
+	serverStatsAfter, err := fetchServerStats()
+	if err != nil {
+		fmt.Println("Could not fetch server stats :", err)
+		return
+	}
+	serverElapsed := serverStatsAfter.UptimeSeconds - serverStatsBefore.UptimeSeconds
+	updatesReceived := serverStatsAfter.UpdatesReceived - serverStatsBefore.UpdatesReceived
+	fmt.Println("\nServer side :")
+	fmt.Printf(" - Updates processed : %d (%.1f/s)\n", updatesReceived, float64(updatesReceived)/serverElapsed)
+	fmt.Printf(" - Updates rejected : %d\n", serverStatsAfter.UpdatesRejected-serverStatsBefore.UpdatesRejected)
+	fmt.Printf(" - Robots registered : %d\n", serverStatsAfter.RobotsRegistered-serverStatsBefore.RobotsRegistered)
+	fmt.Printf(" - Path images generated : %d\n", serverStatsAfter.PathImagesGenerated-serverStatsBefore.PathImagesGenerated)
+}
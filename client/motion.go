@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	. "paltech.robot/robot"
+	"paltech.robot/robot/geo"
+)
+
+const (
+	PatternRandom    = "random"
+	PatternLawnmower = "lawnmower"
+)
+
+// Differential drive limits and the driving pattern of the simulated robots
+type MotionSettings struct {
+	Pattern string
+	// Distance between two passes of the lawnmower pattern
+	LaneSpacingMeters          float64
+	MaxAccelerationMetersPerS2 float64
+	MaxYawRateRadiansPerS      float64
+	TrackWidthMeters           float64
+	// Integration step between two reported statuses, in simulated seconds
+	SubStepSeconds float64
+}
+
+const DefaultLaneSpacingMeters float64 = 20
+const DefaultMaxAcceleration float64 = 0.5
+const DefaultMaxYawRate float64 = math.Pi / 6
+const DefaultTrackWidthMeters float64 = 1.2
+const DefaultSubStepSeconds float64 = 0.5
+
+// Within this distance a waypoint counts as reached
+const WaypointToleranceMeters float64 = 2
+const WaypointSuccessProbability float64 = 0.9
+
+// Gain of the proportional heading controller, in rad/s per radian of heading error
+const HeadingControllerGain float64 = 1
+
+var defaultMotionSettings = MotionSettings{
+	Pattern:                    PatternRandom,
+	LaneSpacingMeters:          DefaultLaneSpacingMeters,
+	MaxAccelerationMetersPerS2: DefaultMaxAcceleration,
+	MaxYawRateRadiansPerS:      DefaultMaxYawRate,
+	TrackWidthMeters:           DefaultTrackWidthMeters,
+	SubStepSeconds:             DefaultSubStepSeconds,
+}
+
+func (motionSettings *MotionSettings) Validate() error {
+	if motionSettings.Pattern != PatternRandom && motionSettings.Pattern != PatternLawnmower {
+		return errors.New("Motion pattern must be " + PatternRandom + " or " + PatternLawnmower)
+	}
+	if motionSettings.LaneSpacingMeters <= 0 || motionSettings.MaxAccelerationMetersPerS2 <= 0 ||
+		motionSettings.MaxYawRateRadiansPerS <= 0 || motionSettings.TrackWidthMeters <= 0 ||
+		motionSettings.SubStepSeconds <= 0 {
+		return errors.New("Lane spacing, acceleration, yaw rate, track width and sub-step must be strictly positive")
+	}
+	return nil
+}
+
+// Keeps an angle in the ]-pi, pi] range
+func normalizeAngle(angle float64) float64 {
+	angle = math.Mod(angle+math.Pi, 2*math.Pi)
+	if angle <= 0 {
+		angle += 2 * math.Pi
+	}
+	return angle - math.Pi
+}
+
+func clamp(value float64, min float64, max float64) float64 {
+	return math.Max(min, math.Min(max, value))
+}
+
+// Boustrophedon pass over the area : lanes going east then west, from the south edge to the north edge
+func getLawnmowerWaypoints(area *GardenArea, laneSpacingMeters float64) []geo.Point {
+	southWest := geo.NewPoint(area.MinLatitude, area.MinLongitude)
+	areaEast, areaNorth := geo.ToENU(southWest, geo.NewPoint(area.MaxLatitude, area.MaxLongitude))
+
+	waypoints := make([]geo.Point, 0)
+	for lane := 0; float64(lane)*laneSpacingMeters <= areaNorth; lane++ {
+		north := float64(lane) * laneSpacingMeters
+		laneStart := geo.FromENU(southWest, 0, north)
+		laneEnd := geo.FromENU(southWest, areaEast, north)
+		if lane%2 == 1 {
+			laneStart, laneEnd = laneEnd, laneStart
+		}
+		waypoints = append(waypoints, laneStart, laneEnd)
+	}
+	return waypoints
+}
+
+// Kinematic state of a simulated differential drive robot, driving from waypoint to waypoint.
+// Heading follows the convention of RobotStatus : radians counterclockwise from east.
+type MotionModel struct {
+	settings    *MotionSettings
+	area        *GardenArea
+	random      *rand.Rand
+	heading     float64
+	speed       float64
+	yawRate     float64
+	cruiseSpeed float64
+	waypoints   []geo.Point
+	// Only used by the lawnmower pattern, random waypoints are drawn when needed
+	nextWaypointIndex int
+	// Where the robot started, it drives back there and stays on a return to base command
+	base              geo.Point
+	isReturningToBase bool
+	// Waypoints set by a command are driven in order, whatever the pattern
+	hasCommandedWaypoints bool
+}
+
+// Number of waypoints of the pattern, or 0 if waypoints are drawn endlessly
+func (model *MotionModel) GetPatternLength() int {
+	if model.settings.Pattern == PatternLawnmower {
+		return len(model.waypoints)
+	}
+	return 0
+}
+
+func NewMotionModel(motionSettings *MotionSettings, area *GardenArea, random *rand.Rand) *MotionModel {
+	model := &MotionModel{
+		settings: motionSettings,
+		area:     area,
+		random:   random,
+		heading:  randFloat64(random, -math.Pi, math.Pi),
+	}
+	if motionSettings.Pattern == PatternLawnmower {
+		// Starts on the first lane, picking the next waypoint would leave it for last
+		model.waypoints = getLawnmowerWaypoints(area, motionSettings.LaneSpacingMeters)
+		model.cruiseSpeed = randFloat64(random, MinForwardSpeed, MaxForwardSpeed)
+	} else {
+		model.pickNextWaypoint()
+	}
+	return model
+}
+
+func (model *MotionModel) getTarget() geo.Point {
+	if model.isReturningToBase {
+		return model.base
+	}
+	return model.waypoints[model.nextWaypointIndex]
+}
+
+func (model *MotionModel) SetBase(base geo.Point) {
+	model.base = base
+}
+
+func (model *MotionModel) IsReturningToBase() bool {
+	return model.isReturningToBase
+}
+
+// The waypoint being driven to is kept, the robot resumes its pattern from there
+func (model *MotionModel) ReturnToBase() {
+	model.isReturningToBase = true
+}
+
+func (model *MotionModel) CancelReturnToBase() {
+	model.isReturningToBase = false
+}
+
+// Drives to the next waypoint of the pattern, the caller counts the skipped one
+func (model *MotionModel) SkipWaypoint() {
+	model.pickNextWaypoint()
+}
+
+// Replaces the pattern, the robot drives to the first of the waypoints next
+func (model *MotionModel) SetWaypoints(waypoints []geo.Point) {
+	model.waypoints = waypoints
+	model.nextWaypointIndex = 0
+	model.hasCommandedWaypoints = true
+	model.cruiseSpeed = randFloat64(model.random, MinForwardSpeed, MaxForwardSpeed)
+}
+
+func (model *MotionModel) pickNextWaypoint() {
+	model.cruiseSpeed = randFloat64(model.random, MinForwardSpeed, MaxForwardSpeed)
+	if model.settings.Pattern == PatternLawnmower || model.hasCommandedWaypoints {
+		if len(model.waypoints) > 0 {
+			model.nextWaypointIndex = (model.nextWaypointIndex + 1) % len(model.waypoints)
+		}
+		return
+	}
+	model.waypoints = []geo.Point{geo.NewPoint(
+		randFloat64(model.random, model.area.MinLatitude, model.area.MaxLatitude),
+		randFloat64(model.random, model.area.MinLongitude, model.area.MaxLongitude),
+	)}
+	model.nextWaypointIndex = 0
+}
+
+// Limits the commanded speed and yaw rate to what the wheels can do : on a differential drive
+// each wheel runs at speed -/+ yawRate * trackWidth / 2, and no wheel can exceed the max forward speed
+func (model *MotionModel) limitWheelSpeeds(speed float64, yawRate float64) float64 {
+	turnWheelSpeed := math.Abs(yawRate) * model.settings.TrackWidthMeters / 2
+	return math.Min(speed, math.Max(MaxForwardSpeed-turnWheelSpeed, 0))
+}
+
+// Integrates one sub-step, returns the distance driven and whether the target waypoint was reached
+func (model *MotionModel) step(position geo.Point, dt float64) (geo.Point, float64, bool) {
+	targetEast, targetNorth := geo.ToENU(position, model.getTarget())
+	distanceToTarget := math.Hypot(targetEast, targetNorth)
+	if distanceToTarget <= WaypointToleranceMeters {
+		model.yawRate = 0
+		return position, 0, true
+	}
+
+	headingError := normalizeAngle(math.Atan2(targetNorth, targetEast) - model.heading)
+	maxYawRate := model.settings.MaxYawRateRadiansPerS
+	yawRate := clamp(HeadingControllerGain*headingError, -maxYawRate, maxYawRate)
+	model.yawRate = yawRate
+
+	// Slows down to turn on the spot when facing away from the target,
+	// and to stop on the waypoint instead of overshooting it
+	desiredSpeed := model.cruiseSpeed * math.Max(math.Cos(headingError), 0)
+	brakingSpeed := math.Sqrt(2 * model.settings.MaxAccelerationMetersPerS2 * distanceToTarget)
+	desiredSpeed = model.limitWheelSpeeds(math.Min(desiredSpeed, brakingSpeed), yawRate)
+	maxSpeedChange := model.settings.MaxAccelerationMetersPerS2 * dt
+	model.speed = clamp(desiredSpeed, model.speed-maxSpeedChange, model.speed+maxSpeedChange)
+	model.speed = math.Max(model.speed, 0)
+
+	// Midpoint heading keeps arcs accurate with coarse sub-steps
+	midHeading := model.heading + yawRate*dt/2
+	model.heading = normalizeAngle(model.heading + yawRate*dt)
+	distance := model.speed * dt
+	nextPosition := geo.FromENU(position, distance*math.Cos(midHeading), distance*math.Sin(midHeading))
+	return nextPosition, distance, false
+}
+
+func (model *MotionModel) setStatusMotion(status *RobotStatus) {
+	status.SetDirectionAndForwardSpeed(model.heading, model.speed)
+	status.SetYawRate(model.yawRate)
+	status.SetHeadingDegrees(DirectionAngleToHeading(model.heading))
+}
+
+// Stays in place until newTimestamp, e.g. while charging
+func (model *MotionModel) Hold(status *RobotStatus, newTimestamp int64) *RobotStatus {
+	var nextStatus RobotStatus = *status
+	model.speed = 0
+	model.yawRate = 0
+	model.setStatusMotion(&nextStatus)
+	nextStatus.Timestamp = newTimestamp
+	return &nextStatus
+}
+
+// Drives from the status until newTimestamp, returning the status the robot reports then
+func (model *MotionModel) Advance(status *RobotStatus, newTimestamp int64) *RobotStatus {
+	var nextStatus RobotStatus = *status
+	position := nextStatus.GetPosition()
+	remainingSeconds := float64(newTimestamp - status.Timestamp)
+
+	for remainingSeconds > 0 && nextStatus.WaypointsReached < nextStatus.WaypointsTotal {
+		dt := math.Min(model.settings.SubStepSeconds, remainingSeconds)
+		nextPosition, distance, isWaypointReached := model.step(position, dt)
+		position = nextPosition
+		nextStatus.DistanceCovered += distance
+		if isWaypointReached && model.isReturningToBase {
+			model.speed = 0
+			break
+		}
+		if isWaypointReached {
+			nextStatus.WaypointsReached += 1
+			if randFloat64(model.random, 0, 1) <= WaypointSuccessProbability {
+				nextStatus.WaypointsSuccessful += 1
+			}
+			model.pickNextWaypoint()
+			continue
+		}
+		remainingSeconds -= dt
+	}
+
+	if nextStatus.WaypointsReached >= nextStatus.WaypointsTotal {
+		model.speed = 0
+		model.yawRate = 0
+	}
+	position.Latitude = clamp(position.Latitude, model.area.MinLatitude, model.area.MaxLatitude)
+	position.Longitude = clamp(position.Longitude, model.area.MinLongitude, model.area.MaxLongitude)
+	nextStatus.SetPosition(position)
+	model.setStatusMotion(&nextStatus)
+	nextStatus.Timestamp = newTimestamp
+	return &nextStatus
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	. "paltech.robot/robot"
+)
+
+// One line of a recording file. RobotId is the id the server gave when recording,
+// replayed robots register again and get new ids.
+type RecordedStatus struct {
+	RobotId int         `json:"robot_id"`
+	Status  RobotStatus `json:"status"`
+}
+
+var recordingFile *os.File
+var recordingEncoder *json.Encoder
+var recordingMutex sync.Mutex
+
+func startRecording(filepath string) error {
+	file, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	recordingFile = file
+	recordingEncoder = json.NewEncoder(file)
+	fmt.Println("Recording statuses to", filepath)
+	return nil
+}
+
+func stopRecording() {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	recordingFile.Close()
+	recordingFile = nil
+	recordingEncoder = nil
+}
+
+// Does nothing unless recording was started
+func recordStatus(robotId int, status *RobotStatus) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	if recordingEncoder == nil {
+		return
+	}
+	if err := recordingEncoder.Encode(RecordedStatus{RobotId: robotId, Status: *status}); err != nil {
+		fmt.Println("Could not record status of robot", robotId, ":", err)
+	}
+}
+
+// Returns the recorded statuses of each robot, robots ordered by first appearance
+func readRecording(filepath string) ([][]*RobotStatus, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	robotsStatuses := make([][]*RobotStatus, 0)
+	recordedIdToIndex := make(map[int]int)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		recorded := new(RecordedStatus)
+		if err := json.Unmarshal(scanner.Bytes(), recorded); err != nil {
+			return nil, fmt.Errorf("Invalid recording line %d : %w", lineNumber, err)
+		}
+
+		index, isKnown := recordedIdToIndex[recorded.RobotId]
+		if !isKnown {
+			index = len(robotsStatuses)
+			recordedIdToIndex[recorded.RobotId] = index
+			robotsStatuses = append(robotsStatuses, make([]*RobotStatus, 0))
+		}
+		robotsStatuses[index] = append(robotsStatuses[index], &recorded.Status)
+	}
+	return robotsStatuses, scanner.Err()
+}
+
+func replayRobot(statuses []*RobotStatus, recordingStartTimestamp int64) {
+	defer wg.Done()
+
+	time.Sleep(getSleepTime(int(statuses[0].Timestamp - recordingStartTimestamp)))
+	robotId, err := requestCreateRobot(statuses[0])
+	if err != nil {
+		fmt.Println("Could not register a replayed robot, skipping it :", err)
+		return
+	}
+	for i := 1; i < len(statuses); i++ {
+		time.Sleep(getSleepTime(int(statuses[i].Timestamp - statuses[i-1].Timestamp)))
+		if _, _, err := requestUpdateRobot(statuses[i], robotId); err != nil {
+			// The recording goes on, like a robot losing its network for a while
+			fmt.Println("Could not replay status", i, "of robot", robotId, ":", err)
+			continue
+		}
+		fmt.Println("Replayed status", i, "/", len(statuses)-1, "of robot", robotId)
+	}
+}
+
+// Replays every recorded robot concurrently, keeping the recorded delays between statuses
+func replayRecording(filepath string) error {
+	robotsStatuses, err := readRecording(filepath)
+	if err != nil {
+		return err
+	}
+	if len(robotsStatuses) == 0 {
+		return fmt.Errorf("Recording %s is empty", filepath)
+	}
+
+	recordingStartTimestamp := robotsStatuses[0][0].Timestamp
+	for _, statuses := range robotsStatuses {
+		if statuses[0].Timestamp < recordingStartTimestamp {
+			recordingStartTimestamp = statuses[0].Timestamp
+		}
+	}
+
+	wg.Add(len(robotsStatuses))
+	for _, statuses := range robotsStatuses {
+		go replayRobot(statuses, recordingStartTimestamp)
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	. "paltech.robot/robot"
+	"paltech.robot/robot/geo"
+)
+
+// What the commands sent by the server changed in a simulated robot
+type robotCommandState struct {
+	isPaused  bool
+	isAborted bool
+	// Skipped since the latest status, counted as reached but not successful in the next one
+	skippedWaypoints int
+	// Set by set_waypoints for the next status, 0 keeps the current total
+	waypointsTotal int
+	// Set by set_report_interval, 0 keeps the random intervals of the simulation
+	reportIntervalSeconds int
+	// Sent with every status until one of them reaches the server
+	acknowledgements []CommandAcknowledgement
+}
+
+// Aborted robots stop once they sent the acknowledgement of the abort
+func (state *robotCommandState) hasAbortedMission() bool {
+	return state.isAborted && len(state.acknowledgements) == 0
+}
+
+func (state *robotCommandState) getNextUpdateDelaySeconds(randomDelaySeconds int) int {
+	if state.reportIntervalSeconds > 0 {
+		return state.reportIntervalSeconds
+	}
+	return randomDelaySeconds
+}
+
+func (state *robotCommandState) setWaypoints(waypoints []Waypoint, latestStatus *RobotStatus, motion *MotionModel) error {
+	if len(waypoints) == 0 {
+		return errors.New("No waypoint to drive to")
+	}
+	points := make([]geo.Point, 0, len(waypoints))
+	for i, waypoint := range waypoints {
+		point := geo.NewPoint(waypoint.Latitude, waypoint.Longitude)
+		if !settings.GardenArea.Contains(point) {
+			return errors.New("Waypoint " + strconv.Itoa(i) + " is outside of " + settings.GardenArea.Name)
+		}
+		points = append(points, point)
+	}
+	motion.SetWaypoints(points)
+	state.waypointsTotal = latestStatus.WaypointsReached + state.skippedWaypoints + len(waypoints)
+	return nil
+}
+
+func (state *robotCommandState) execute(command RobotCommand, latestStatus *RobotStatus, motion *MotionModel) error {
+	if state.isAborted {
+		return errors.New("Mission aborted")
+	}
+	switch command.Type {
+	case CommandPause:
+		if state.isPaused {
+			return errors.New("Already paused")
+		}
+		state.isPaused = true
+	case CommandAbort:
+		state.isAborted = true
+		state.isPaused = false
+		motion.CancelReturnToBase()
+	case CommandResume:
+		if !state.isPaused && !motion.IsReturningToBase() {
+			return errors.New("Neither paused nor returning to base")
+		}
+		state.isPaused = false
+		motion.CancelReturnToBase()
+	case CommandReturnToBase:
+		if motion.IsReturningToBase() {
+			return errors.New("Already returning to base")
+		}
+		state.isPaused = false
+		motion.ReturnToBase()
+	case CommandSkipWaypoint:
+		if motion.IsReturningToBase() {
+			return errors.New("Returning to base, there is no waypoint to skip")
+		}
+		if latestStatus.WaypointsReached+state.skippedWaypoints >= latestStatus.WaypointsTotal-1 {
+			return errors.New("Can not skip the last waypoint")
+		}
+		motion.SkipWaypoint()
+		state.skippedWaypoints++
+	case CommandSetWaypoints:
+		if motion.IsReturningToBase() {
+			return errors.New("Returning to base, resume before setting waypoints")
+		}
+		return state.setWaypoints(command.Waypoints, latestStatus, motion)
+	case CommandSetReportInterval:
+		if command.ReportIntervalSeconds <= 0 {
+			return errors.New("Report interval must be strictly positive")
+		}
+		state.reportIntervalSeconds = command.ReportIntervalSeconds
+	default:
+		return errors.New("Unknown command " + string(command.Type))
+	}
+	return nil
+}
+
+func (state *robotCommandState) apply(commands []RobotCommand, robot *Robot, motion *MotionModel) {
+	for _, command := range commands {
+		acknowledgement := CommandAcknowledgement{CommandId: command.Id, IsSuccessful: true}
+		if err := state.execute(command, robot.GetLatestStatus(), motion); err != nil {
+			acknowledgement.IsSuccessful = false
+			acknowledgement.Error = err.Error()
+			fmt.Println("Robot", robot.Id, "could not execute command", command.Id, command.Type, ":", err)
+		} else {
+			fmt.Println("Robot", robot.Id, "executed command", command.Id, command.Type)
+		}
+		state.acknowledgements = append(state.acknowledgements, acknowledgement)
+	}
+}
+
+// Forgets the acknowledgements of a status the server accepted, the commands applied since then are still to acknowledge
+func (state *robotCommandState) confirmAcknowledgements(sent []CommandAcknowledgement) {
+	state.acknowledgements = state.acknowledgements[len(sent):]
+}
+
+// Applies the skipped waypoints, the new waypoints, the acknowledgements and the current task to the next status
+func (state *robotCommandState) updateStatus(status *RobotStatus, motion *MotionModel) {
+	status.WaypointsReached += state.skippedWaypoints
+	state.skippedWaypoints = 0
+	if state.waypointsTotal > 0 {
+		status.WaypointsTotal = state.waypointsTotal
+		state.waypointsTotal = 0
+	}
+	status.Acknowledgements = append([]CommandAcknowledgement(nil), state.acknowledgements...)
+
+	if !status.HasTelemetry() || status.Telemetry.IsCharging {
+		return
+	}
+	if state.isPaused || state.isAborted {
+		status.Telemetry.CurrentTask = TaskIdle
+	} else if motion.IsReturningToBase() {
+		status.Telemetry.CurrentTask = TaskReturningToBase
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	. "paltech.robot/robot"
+)
+
+const BatteryDrainPercentPerSecond float64 = 0.01
+const BatteryDrainPercentPerMeter float64 = 0.004
+const BatteryChargePercentPerSecond float64 = 0.03
+
+// Robots stop to charge on the spot below the first level, and resume working above the second one
+const BatteryChargeStartPercent float64 = 15
+const BatteryChargeStopPercent float64 = 95
+const AmbientTemperatureC float64 = 20
+
+// Motor temperature reached at full speed, above the ambient temperature
+const MotorHeatingAtFullSpeedC float64 = 45
+const MotorOverheatTemperatureC float64 = 70
+const MotorsCount = 2
+const MinSatelliteCount = 6
+const MaxSatelliteCount = 20
+const RTKFloatProbability float64 = 0.1
+
+const ErrorCodeMotorOverheat = "E_MOTOR_OVERHEAT"
+const ErrorCodeGNSSDegraded = "W_GNSS_DEGRADED"
+
+// Battery, motors and GNSS receiver of a simulated robot
+type TelemetryModel struct {
+	random     *rand.Rand
+	isCharging bool
+}
+
+func NewTelemetryModel(random *rand.Rand) *TelemetryModel {
+	return &TelemetryModel{random: random}
+}
+
+func (model *TelemetryModel) IsCharging() bool {
+	return model.isCharging
+}
+
+func (model *TelemetryModel) GetInitialTelemetry() *Telemetry {
+	telemetry := &Telemetry{
+		BatteryPercent: randFloat64(model.random, 60, 100),
+		CurrentTask:    TaskNavigating,
+	}
+	model.updateSensors(telemetry, 0)
+	return telemetry
+}
+
+func (model *TelemetryModel) updateSensors(telemetry *Telemetry, forwardSpeed float64) {
+	telemetry.ErrorCodes = nil
+	telemetry.MotorTemperaturesC = make([]float64, MotorsCount)
+	for i := range telemetry.MotorTemperaturesC {
+		heating := MotorHeatingAtFullSpeedC * forwardSpeed / MaxForwardSpeed
+		telemetry.MotorTemperaturesC[i] = AmbientTemperatureC + heating + model.random.NormFloat64()*3
+	}
+	if telemetry.GetMaxMotorTemperature() > MotorOverheatTemperatureC {
+		telemetry.ErrorCodes = append(telemetry.ErrorCodes, ErrorCodeMotorOverheat)
+	}
+
+	telemetry.SatelliteCount = randInt(model.random, MinSatelliteCount, MaxSatelliteCount)
+	telemetry.GNSSFix = GNSSFixRTKFixed
+	if randFloat64(model.random, 0, 1) < RTKFloatProbability {
+		telemetry.GNSSFix = GNSSFixRTKFloat
+		telemetry.ErrorCodes = append(telemetry.ErrorCodes, ErrorCodeGNSSDegraded)
+	}
+}
+
+// Sets the telemetry of next, a status generated from previous
+func (model *TelemetryModel) Advance(previous *RobotStatus, next *RobotStatus) {
+	// Statuses are copied by value, the telemetry must not be shared with the previous status
+	telemetry := *previous.Telemetry
+	elapsedSeconds := float64(next.Timestamp - previous.Timestamp)
+	distance := next.DistanceCovered - previous.DistanceCovered
+
+	if model.isCharging {
+		telemetry.BatteryPercent += BatteryChargePercentPerSecond * elapsedSeconds
+		if telemetry.BatteryPercent >= BatteryChargeStopPercent {
+			model.isCharging = false
+		}
+	} else {
+		telemetry.BatteryPercent -= BatteryDrainPercentPerSecond*elapsedSeconds + BatteryDrainPercentPerMeter*distance
+		if telemetry.BatteryPercent <= BatteryChargeStartPercent {
+			model.isCharging = true
+		}
+	}
+	telemetry.BatteryPercent = math.Max(0, math.Min(100, telemetry.BatteryPercent))
+	telemetry.IsCharging = model.isCharging
+
+	switch {
+	case model.isCharging:
+		telemetry.CurrentTask = TaskCharging
+	case next.WaypointsReached >= next.WaypointsTotal:
+		telemetry.CurrentTask = TaskIdle
+	case settings.Motion.Pattern == PatternLawnmower:
+		telemetry.CurrentTask = TaskWeeding
+	default:
+		telemetry.CurrentTask = TaskNavigating
+	}
+	model.updateSensors(&telemetry, next.GetForwardSpeed())
+	next.Telemetry = &telemetry
+}
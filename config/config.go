@@ -0,0 +1,437 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const ConfigPathEnvironmentVariable = "PALTECH_CONFIG"
+const RedactedSecret = "<redacted>"
+const DailySummaryTimeLayout = "15:04"
+
+type ServerConfig struct {
+	ListenAddress       string `yaml:"listen_address"`
+	PathImagesDirectory string `yaml:"path_images_directory"`
+	// Older path images are removed past any of these limits, 0 disables a limit.
+	// The latest image of every robot is always kept.
+	PathImagesPerRobot          int `yaml:"path_images_per_robot"`
+	PathImagesMaxAgeMinutes     int `yaml:"path_images_max_age_minutes"`
+	PathImagesMaxTotalMegabytes int `yaml:"path_images_max_total_megabytes"`
+	// Removes the path images of a previous run from the directory at startup, robot ids being reused after a restart.
+	// Off by default as the directory may hold other files named like path images.
+	CleanPathImagesOnStart bool `yaml:"clean_path_images_on_start"`
+}
+
+type ClientConfig struct {
+	ServerUrl string `yaml:"server_url"`
+}
+
+type TelegramConfig struct {
+	ApiKey string `yaml:"api_key"`
+	// Chats notified when an alert is left unacknowledged, in addition to the registered chats
+	EscalationChatIds []int64 `yaml:"escalation_chat_ids"`
+	// Users that are always admins of the bot, they approve the join requests of everyone else
+	AdminIds []int64 `yaml:"admin_ids"`
+	// JSON file where the roles granted from Telegram and the pending join requests are saved
+	AccessFile string `yaml:"access_file"`
+	// Edits one pinned fleet overview and one status message per robot in every chat
+	// instead of sending new messages on every periodic update
+	LiveStatusMessages bool `yaml:"live_status_messages"`
+	// Sends one fleet digest every N minutes (60 for hourly) instead of a message per robot, 0 disables it
+	DigestIntervalMinutes int `yaml:"digest_interval_minutes"`
+	// Local "HH:MM" time of the daily fleet summary, empty disables it
+	DailySummaryTime string `yaml:"daily_summary_time"`
+}
+
+type MapsConfig struct {
+	ApiKey          string  `yaml:"api_key"`
+	CenterLatitude  float64 `yaml:"center_lat"`
+	CenterLongitude float64 `yaml:"center_lon"`
+	Zoom            int     `yaml:"zoom"`
+	SizePixels      int     `yaml:"size_pixels"`
+}
+
+// Ground worked by the robots, measured by buffering their path by the tool width on a grid of square cells
+type CoverageConfig struct {
+	ToolWidthMeters float64 `yaml:"tool_width_meters"`
+	CellSizeMeters  float64 `yaml:"cell_size_meters"`
+	// JSON file of the garden area coverage is reported against, the simulator default area when empty
+	GardenAreaFile string `yaml:"garden_area_file"`
+}
+
+// Conditions are parsed by the server, see the robot package for their syntax
+type AlertRuleConfig struct {
+	Name            string `yaml:"name"`
+	Condition       string `yaml:"condition"`
+	Severity        string `yaml:"severity"`
+	CooldownSeconds int64  `yaml:"cooldown_seconds"`
+}
+
+type AlertsConfig struct {
+	// Left empty, the server uses its default rules
+	Rules []AlertRuleConfig `yaml:"rules"`
+	// Unacknowledged alerts are escalated after this delay, 0 disables escalation
+	EscalationDelaySeconds int `yaml:"escalation_delay_seconds"`
+}
+
+// Configuration shared by the server, the simulator client and the Telegram bot.
+// Values are resolved from the defaults, then the YAML file, then environment variables, then command-line flags.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Client   ClientConfig   `yaml:"client"`
+	Telegram TelegramConfig `yaml:"telegram"`
+	Maps     MapsConfig     `yaml:"maps"`
+	Alerts   AlertsConfig   `yaml:"alerts"`
+	Coverage CoverageConfig `yaml:"coverage"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddress:       ":1323",
+			PathImagesDirectory: "pathImages",
+			PathImagesPerRobot:  10,
+		},
+		Client: ClientConfig{
+			ServerUrl: "http://127.0.0.1:1323",
+		},
+		Telegram: TelegramConfig{
+			AccessFile: "telegramAccess.json",
+		},
+		Maps: MapsConfig{
+			CenterLatitude:  48.218885,
+			CenterLongitude: 11.607754,
+			Zoom:            15,
+			SizePixels:      1000,
+		},
+		Alerts: AlertsConfig{
+			EscalationDelaySeconds: 15 * 60,
+		},
+		Coverage: CoverageConfig{
+			ToolWidthMeters: 1,
+			CellSizeMeters:  0.5,
+		},
+	}
+}
+
+// A single configuration value, reachable from the file, the environment and the command line
+type setting struct {
+	flagName string
+	envName  string
+	isSecret bool
+	get      func(config *Config) string
+	set      func(config *Config, value string) error
+}
+
+func stringSetting(flagName string, envName string, isSecret bool, field func(config *Config) *string) setting {
+	return setting{
+		flagName: flagName,
+		envName:  envName,
+		isSecret: isSecret,
+		get:      func(config *Config) string { return *field(config) },
+		set: func(config *Config, value string) error {
+			*field(config) = value
+			return nil
+		},
+	}
+}
+
+func floatSetting(flagName string, envName string, field func(config *Config) *float64) setting {
+	return setting{
+		flagName: flagName,
+		envName:  envName,
+		get:      func(config *Config) string { return strconv.FormatFloat(*field(config), 'f', -1, 64) },
+		set: func(config *Config, value string) error {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+			*field(config) = parsed
+			return nil
+		},
+	}
+}
+
+func intSetting(flagName string, envName string, field func(config *Config) *int) setting {
+	return setting{
+		flagName: flagName,
+		envName:  envName,
+		get:      func(config *Config) string { return strconv.Itoa(*field(config)) },
+		set: func(config *Config, value string) error {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			*field(config) = parsed
+			return nil
+		},
+	}
+}
+
+func boolSetting(flagName string, envName string, field func(config *Config) *bool) setting {
+	return setting{
+		flagName: flagName,
+		envName:  envName,
+		get:      func(config *Config) string { return strconv.FormatBool(*field(config)) },
+		set: func(config *Config, value string) error {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			*field(config) = parsed
+			return nil
+		},
+	}
+}
+
+// Comma separated list, e.g. "-1001234,5678"
+func int64ListSetting(flagName string, envName string, field func(config *Config) *[]int64) setting {
+	return setting{
+		flagName: flagName,
+		envName:  envName,
+		get: func(config *Config) string {
+			values := make([]string, 0, len(*field(config)))
+			for _, value := range *field(config) {
+				values = append(values, strconv.FormatInt(value, 10))
+			}
+			return strings.Join(values, ",")
+		},
+		set: func(config *Config, value string) error {
+			parsedValues := make([]int64, 0)
+			for _, item := range strings.Split(value, ",") {
+				item = strings.TrimSpace(item)
+				if item == "" {
+					continue
+				}
+				parsed, err := strconv.ParseInt(item, 10, 64)
+				if err != nil {
+					return err
+				}
+				parsedValues = append(parsedValues, parsed)
+			}
+			*field(config) = parsedValues
+			return nil
+		},
+	}
+}
+
+var settings = []setting{
+	stringSetting("server-listen-address", "PALTECH_SERVER_LISTEN_ADDRESS", false,
+		func(config *Config) *string { return &config.Server.ListenAddress }),
+	stringSetting("server-path-images-directory", "PALTECH_SERVER_PATH_IMAGES_DIRECTORY", false,
+		func(config *Config) *string { return &config.Server.PathImagesDirectory }),
+	intSetting("server-path-images-per-robot", "PALTECH_SERVER_PATH_IMAGES_PER_ROBOT",
+		func(config *Config) *int { return &config.Server.PathImagesPerRobot }),
+	intSetting("server-path-images-max-age-minutes", "PALTECH_SERVER_PATH_IMAGES_MAX_AGE_MINUTES",
+		func(config *Config) *int { return &config.Server.PathImagesMaxAgeMinutes }),
+	intSetting("server-path-images-max-total-megabytes", "PALTECH_SERVER_PATH_IMAGES_MAX_TOTAL_MEGABYTES",
+		func(config *Config) *int { return &config.Server.PathImagesMaxTotalMegabytes }),
+	boolSetting("server-clean-path-images-on-start", "PALTECH_SERVER_CLEAN_PATH_IMAGES_ON_START",
+		func(config *Config) *bool { return &config.Server.CleanPathImagesOnStart }),
+	stringSetting("client-server-url", "PALTECH_CLIENT_SERVER_URL", false,
+		func(config *Config) *string { return &config.Client.ServerUrl }),
+	stringSetting("telegram-api-key", "PALTECH_TELEGRAM_API_KEY", true,
+		func(config *Config) *string { return &config.Telegram.ApiKey }),
+	int64ListSetting("telegram-escalation-chat-ids", "PALTECH_TELEGRAM_ESCALATION_CHAT_IDS",
+		func(config *Config) *[]int64 { return &config.Telegram.EscalationChatIds }),
+	int64ListSetting("telegram-admin-ids", "PALTECH_TELEGRAM_ADMIN_IDS",
+		func(config *Config) *[]int64 { return &config.Telegram.AdminIds }),
+	stringSetting("telegram-access-file", "PALTECH_TELEGRAM_ACCESS_FILE", false,
+		func(config *Config) *string { return &config.Telegram.AccessFile }),
+	boolSetting("telegram-live-status-messages", "PALTECH_TELEGRAM_LIVE_STATUS_MESSAGES",
+		func(config *Config) *bool { return &config.Telegram.LiveStatusMessages }),
+	intSetting("telegram-digest-interval-minutes", "PALTECH_TELEGRAM_DIGEST_INTERVAL_MINUTES",
+		func(config *Config) *int { return &config.Telegram.DigestIntervalMinutes }),
+	stringSetting("telegram-daily-summary-time", "PALTECH_TELEGRAM_DAILY_SUMMARY_TIME", false,
+		func(config *Config) *string { return &config.Telegram.DailySummaryTime }),
+	stringSetting("maps-api-key", "PALTECH_MAPS_API_KEY", true,
+		func(config *Config) *string { return &config.Maps.ApiKey }),
+	floatSetting("maps-center-lat", "PALTECH_MAPS_CENTER_LAT",
+		func(config *Config) *float64 { return &config.Maps.CenterLatitude }),
+	floatSetting("maps-center-lon", "PALTECH_MAPS_CENTER_LON",
+		func(config *Config) *float64 { return &config.Maps.CenterLongitude }),
+	intSetting("maps-zoom", "PALTECH_MAPS_ZOOM",
+		func(config *Config) *int { return &config.Maps.Zoom }),
+	intSetting("maps-size-pixels", "PALTECH_MAPS_SIZE_PIXELS",
+		func(config *Config) *int { return &config.Maps.SizePixels }),
+	intSetting("alerts-escalation-delay-seconds", "PALTECH_ALERTS_ESCALATION_DELAY_SECONDS",
+		func(config *Config) *int { return &config.Alerts.EscalationDelaySeconds }),
+	floatSetting("coverage-tool-width-meters", "PALTECH_COVERAGE_TOOL_WIDTH_METERS",
+		func(config *Config) *float64 { return &config.Coverage.ToolWidthMeters }),
+	floatSetting("coverage-cell-size-meters", "PALTECH_COVERAGE_CELL_SIZE_METERS",
+		func(config *Config) *float64 { return &config.Coverage.CellSizeMeters }),
+	stringSetting("coverage-garden-area-file", "PALTECH_COVERAGE_GARDEN_AREA_FILE", false,
+		func(config *Config) *string { return &config.Coverage.GardenAreaFile }),
+}
+
+type flagOverride struct {
+	setting *setting
+	value   string
+}
+
+// Registers the configuration flags on a flag set, Load must be called once the flag set is parsed
+type Loader struct {
+	configPath    string
+	printConfig   bool
+	flagOverrides []flagOverride
+}
+
+func NewLoader(flagSet *flag.FlagSet) *Loader {
+	loader := new(Loader)
+	flagSet.StringVar(&loader.configPath, "config", os.Getenv(ConfigPathEnvironmentVariable),
+		"Path to a YAML configuration file (env "+ConfigPathEnvironmentVariable+")")
+	flagSet.BoolVar(&loader.printConfig, "print-config", false,
+		"Print the resolved configuration with secrets redacted, then exit")
+
+	for i := range settings {
+		currentSetting := &settings[i]
+		flagSet.Func(currentSetting.flagName, "Overrides env "+currentSetting.envName, func(value string) error {
+			loader.flagOverrides = append(loader.flagOverrides, flagOverride{setting: currentSetting, value: value})
+			return nil
+		})
+	}
+	return loader
+}
+
+func (loader *Loader) ShouldPrintConfig() bool {
+	return loader.printConfig
+}
+
+func (loader *Loader) Load() (*Config, error) {
+	config := Default()
+
+	if loader.configPath != "" {
+		file, err := os.Open(loader.configPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("Could not parse %s : %w", loader.configPath, err)
+		}
+	}
+
+	for _, currentSetting := range settings {
+		value, isSet := os.LookupEnv(currentSetting.envName)
+		if !isSet {
+			continue
+		}
+		if err := currentSetting.set(config, value); err != nil {
+			return nil, fmt.Errorf("Invalid value for %s : %w", currentSetting.envName, err)
+		}
+	}
+
+	for _, override := range loader.flagOverrides {
+		if err := override.setting.set(config, override.value); err != nil {
+			return nil, fmt.Errorf("Invalid value for -%s : %w", override.setting.flagName, err)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (config *Config) Validate() error {
+	if config.Server.ListenAddress == "" {
+		return errors.New("Server listen address must not be empty")
+	}
+	if config.Server.PathImagesDirectory == "" {
+		return errors.New("Path images directory must not be empty")
+	}
+	if config.Server.PathImagesPerRobot < 0 || config.Server.PathImagesMaxAgeMinutes < 0 || config.Server.PathImagesMaxTotalMegabytes < 0 {
+		return errors.New("Path images limits must not be negative")
+	}
+	if config.Telegram.AccessFile == "" {
+		return errors.New("Telegram access file must not be empty")
+	}
+	if config.Telegram.DigestIntervalMinutes < 0 {
+		return errors.New("Telegram digest interval must not be negative")
+	}
+	if config.Telegram.DailySummaryTime != "" {
+		if _, err := time.Parse(DailySummaryTimeLayout, config.Telegram.DailySummaryTime); err != nil {
+			return errors.New("Telegram daily summary time must be formatted as HH:MM, got " + config.Telegram.DailySummaryTime)
+		}
+	}
+	serverUrl, err := url.Parse(config.Client.ServerUrl)
+	if err != nil || (serverUrl.Scheme != "http" && serverUrl.Scheme != "https") || serverUrl.Host == "" {
+		return errors.New("Client server URL must be an absolute http(s) URL, got " + config.Client.ServerUrl)
+	}
+	if config.Maps.CenterLatitude < -90 || config.Maps.CenterLatitude > 90 {
+		return errors.New("Map center latitude must be between -90 and 90")
+	}
+	if config.Maps.CenterLongitude < -180 || config.Maps.CenterLongitude > 180 {
+		return errors.New("Map center longitude must be between -180 and 180")
+	}
+	if config.Maps.Zoom < 0 || config.Maps.Zoom > 21 {
+		return errors.New("Map zoom must be between 0 and 21")
+	}
+	if config.Maps.SizePixels <= 0 || config.Maps.SizePixels > 2048 {
+		return errors.New("Map size must be between 1 and 2048 pixels")
+	}
+	if config.Alerts.EscalationDelaySeconds < 0 {
+		return errors.New("Alerts escalation delay must not be negative")
+	}
+	if config.Coverage.ToolWidthMeters <= 0 {
+		return errors.New("Coverage tool width must be strictly positive")
+	}
+	// Finer cells make every coverage request scan millions of cells
+	if config.Coverage.CellSizeMeters < 0.05 || config.Coverage.CellSizeMeters > config.Coverage.ToolWidthMeters {
+		return errors.New("Coverage cell size must be between 0.05m and the tool width")
+	}
+	return nil
+}
+
+// Copy of the configuration safe to print or log
+func (config *Config) Redacted() *Config {
+	redacted := *config
+	for _, currentSetting := range settings {
+		if currentSetting.isSecret && currentSetting.get(&redacted) != "" {
+			currentSetting.set(&redacted, RedactedSecret)
+		}
+	}
+	return &redacted
+}
+
+func (config *Config) Print(writer io.Writer) error {
+	encoder := yaml.NewEncoder(writer)
+	defer encoder.Close()
+	return encoder.Encode(config.Redacted())
+}
+
+// Loads the configuration and handles -print-config, exiting the process on errors
+func (loader *Loader) MustLoad() *Config {
+	config, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if loader.ShouldPrintConfig() {
+		if err := config.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	return config
+}
+
+// Parses the process command line, loads the configuration and handles -print-config.
+// Meant for programs that have no flags of their own.
+func MustLoadFromCommandLine() *Config {
+	loader := NewLoader(flag.CommandLine)
+	flag.Parse()
+	return loader.MustLoad()
+}
@@ -0,0 +1,315 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AlertSeverity string
+
+const (
+	SeverityInfo     AlertSeverity = "info"
+	SeverityWarning  AlertSeverity = "warning"
+	SeverityCritical AlertSeverity = "critical"
+)
+
+// Rule names are in the data of the Telegram alert buttons, limited to 64 bytes along with the action and robot id
+const MaxAlertRuleNameBytes = 40
+
+// A rule raises an alert for a robot while its condition holds. Conditions are clauses joined by "and" :
+//   - "<metric> <op> <threshold>" compares the latest value, e.g. "battery < 20"
+//   - "<metric> <op> <threshold> for <duration>" requires it over the whole duration, e.g. "speed < 0.05 for 10m"
+//   - "<metric> increase <op> <threshold> in <duration>" compares the change over the duration,
+//     e.g. "distance_covered increase > 500m in 1m"
+//
+// Durations are in robot time, thresholds may carry a unit suffix which is ignored.
+type AlertRule struct {
+	Name            string        `json:"name"`
+	Condition       string        `json:"condition"`
+	Severity        AlertSeverity `json:"severity"`
+	CooldownSeconds int64         `json:"cooldown_seconds"`
+	clauses         []*ruleClause
+}
+
+// Returns the value of the metric for a status, and false when it is not available,
+// e.g. telemetry for robots not sending it. kinematics is nil for the first status.
+type metricFunction func(status *RobotStatus, kinematics *Kinematics) (float64, bool)
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+var metrics = map[string]metricFunction{
+	"speed": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		return status.GetForwardSpeed(), true
+	},
+	"gps_speed": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if kinematics == nil {
+			return 0, false
+		}
+		return kinematics.GPSSpeed, true
+	},
+	"heading": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		return status.GetHeadingDegrees(), true
+	},
+	"turn_rate": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		return status.GetTurnRateDegrees(), true
+	},
+	"distance_covered": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		return status.DistanceCovered, true
+	},
+	"waypoints_reached": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		return float64(status.WaypointsReached), true
+	},
+	"waypoints_successful": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		return float64(status.WaypointsSuccessful), true
+	},
+	"waypoint_success_rate": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if status.WaypointsReached == 0 {
+			return 0, false
+		}
+		return float64(status.WaypointsSuccessful) / float64(status.WaypointsReached), true
+	},
+	"completion": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if status.WaypointsTotal == 0 {
+			return 0, false
+		}
+		return float64(status.WaypointsReached) / float64(status.WaypointsTotal), true
+	},
+	"anomalies": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if kinematics == nil {
+			return 0, false
+		}
+		return float64(len(kinematics.Anomalies)), true
+	},
+	"battery": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if !status.HasTelemetry() {
+			return 0, false
+		}
+		return status.Telemetry.BatteryPercent, true
+	},
+	"is_charging": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if !status.HasTelemetry() {
+			return 0, false
+		}
+		return boolToFloat(status.Telemetry.IsCharging), true
+	},
+	"motor_temperature": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if !status.HasTelemetry() || len(status.Telemetry.MotorTemperaturesC) == 0 {
+			return 0, false
+		}
+		return status.Telemetry.GetMaxMotorTemperature(), true
+	},
+	"satellites": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if !status.HasTelemetry() {
+			return 0, false
+		}
+		return float64(status.Telemetry.SatelliteCount), true
+	},
+	"error_count": func(status *RobotStatus, kinematics *Kinematics) (float64, bool) {
+		if !status.HasTelemetry() {
+			return 0, false
+		}
+		return float64(len(status.Telemetry.ErrorCodes)), true
+	},
+}
+
+func GetMetricNames() []string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var comparators = map[string]func(value float64, threshold float64) bool{
+	"<":  func(value float64, threshold float64) bool { return value < threshold },
+	"<=": func(value float64, threshold float64) bool { return value <= threshold },
+	">":  func(value float64, threshold float64) bool { return value > threshold },
+	">=": func(value float64, threshold float64) bool { return value >= threshold },
+	"==": func(value float64, threshold float64) bool { return value == threshold },
+	"!=": func(value float64, threshold float64) bool { return value != threshold },
+}
+
+var thresholdUnitSuffixes = []string{"°C", "°/s", "°", "m/s", "m", "%", "s"}
+
+type ruleClause struct {
+	metricName    string
+	metric        metricFunction
+	operator      string
+	compare       func(value float64, threshold float64) bool
+	threshold     float64
+	isIncrease    bool
+	windowSeconds int64
+}
+
+func parseThreshold(token string) (float64, error) {
+	for _, suffix := range thresholdUnitSuffixes {
+		if strings.HasSuffix(token, suffix) {
+			token = strings.TrimSuffix(token, suffix)
+			break
+		}
+	}
+	return strconv.ParseFloat(token, 64)
+}
+
+func parseWindow(token string) (int64, error) {
+	duration, err := time.ParseDuration(token)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, errors.New("Durations must be strictly positive")
+	}
+	return int64(duration.Seconds()), nil
+}
+
+func parseClause(clauseString string) (*ruleClause, error) {
+	tokens := strings.Fields(clauseString)
+	if len(tokens) < 3 {
+		return nil, errors.New("Expected \"<metric> <op> <threshold>\", got \"" + clauseString + "\"")
+	}
+
+	clause := new(ruleClause)
+	clause.metricName = tokens[0]
+	metric, isKnown := metrics[clause.metricName]
+	if !isKnown {
+		return nil, errors.New("Unknown metric " + clause.metricName + ", known metrics are " + strings.Join(GetMetricNames(), ", "))
+	}
+	clause.metric = metric
+
+	tokens = tokens[1:]
+	if tokens[0] == "increase" {
+		clause.isIncrease = true
+		tokens = tokens[1:]
+	}
+	if len(tokens) < 2 {
+		return nil, errors.New("Missing comparison in \"" + clauseString + "\"")
+	}
+
+	clause.operator = tokens[0]
+	compare, isKnown := comparators[clause.operator]
+	if !isKnown {
+		return nil, errors.New("Unknown comparison operator " + clause.operator)
+	}
+	clause.compare = compare
+	threshold, err := parseThreshold(tokens[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid threshold %s : %w", tokens[1], err)
+	}
+	clause.threshold = threshold
+	tokens = tokens[2:]
+
+	expectedWindowKeyword := "for"
+	if clause.isIncrease {
+		expectedWindowKeyword = "in"
+	}
+	switch {
+	case len(tokens) == 0 && clause.isIncrease:
+		return nil, errors.New("An increase needs a duration, e.g. \"in 1m\"")
+	case len(tokens) == 0:
+		return clause, nil
+	case len(tokens) == 2 && tokens[0] == expectedWindowKeyword:
+		clause.windowSeconds, err = parseWindow(tokens[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid duration %s : %w", tokens[1], err)
+		}
+		return clause, nil
+	}
+	return nil, errors.New("Expected \"" + expectedWindowKeyword + " <duration>\" at the end of \"" + clauseString + "\"")
+}
+
+func (rule *AlertRule) Compile() error {
+	if rule.Name == "" {
+		return errors.New("Alert rules need a name")
+	}
+	if len(rule.Name) > MaxAlertRuleNameBytes {
+		return errors.New("Name of rule " + rule.Name + " is longer than " + strconv.Itoa(MaxAlertRuleNameBytes) + " bytes")
+	}
+	switch rule.Severity {
+	case "":
+		rule.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return errors.New("Unknown severity " + string(rule.Severity) + " for rule " + rule.Name)
+	}
+	if rule.CooldownSeconds < 0 {
+		return errors.New("Cooldown of rule " + rule.Name + " must be positive")
+	}
+
+	clauses := make([]*ruleClause, 0)
+	for _, clauseString := range strings.Split(rule.Condition, " and ") {
+		clause, err := parseClause(clauseString)
+		if err != nil {
+			return fmt.Errorf("Rule %s : %w", rule.Name, err)
+		}
+		clauses = append(clauses, clause)
+	}
+	rule.clauses = clauses
+	return nil
+}
+
+func (robot *Robot) getKinematicsForStatus(statusIndex int) *Kinematics {
+	// There is one kinematics per status after the first one
+	if statusIndex == 0 || statusIndex-1 >= len(robot.KinematicsHistory) {
+		return nil
+	}
+	return robot.KinematicsHistory[statusIndex-1]
+}
+
+// Returns whether the clause holds, and the value it was decided on
+func (clause *ruleClause) evaluate(robot *Robot) (bool, float64) {
+	latestIndex := len(robot.StatusHistory) - 1
+	latestValue, isAvailable := clause.metric(robot.StatusHistory[latestIndex], robot.getKinematicsForStatus(latestIndex))
+	if !isAvailable {
+		return false, 0
+	}
+	if clause.windowSeconds == 0 {
+		return clause.compare(latestValue, clause.threshold), latestValue
+	}
+
+	startIndex := robot.getStatusWindowStartIndex(clause.windowSeconds)
+	if startIndex < 0 {
+		return false, latestValue
+	}
+	if clause.isIncrease {
+		startValue, isAvailable := clause.metric(robot.StatusHistory[startIndex], robot.getKinematicsForStatus(startIndex))
+		if !isAvailable {
+			return false, 0
+		}
+		increase := latestValue - startValue
+		return clause.compare(increase, clause.threshold), increase
+	}
+
+	for i := startIndex; i <= latestIndex; i++ {
+		value, isAvailable := clause.metric(robot.StatusHistory[i], robot.getKinematicsForStatus(i))
+		if !isAvailable || !clause.compare(value, clause.threshold) {
+			return false, latestValue
+		}
+	}
+	return true, latestValue
+}
+
+// Returns whether the rule holds for the robot, and the value its first clause was decided on
+func (rule *AlertRule) Evaluate(robot *Robot) (bool, float64) {
+	if len(robot.StatusHistory) == 0 || len(rule.clauses) == 0 {
+		return false, 0
+	}
+	isTriggered, firstValue := true, 0.0
+	for i, clause := range rule.clauses {
+		isClauseTrue, value := clause.evaluate(robot)
+		if i == 0 {
+			firstValue = value
+		}
+		isTriggered = isTriggered && isClauseTrue
+	}
+	return isTriggered, firstValue
+}
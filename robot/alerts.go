@@ -0,0 +1,403 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Alert struct {
+	RobotId        int           `json:"robot_id"`
+	RuleName       string        `json:"rule_name"`
+	Condition      string        `json:"condition"`
+	Severity       AlertSeverity `json:"severity"`
+	Value          float64       `json:"value"`
+	IsActive       bool          `json:"is_active"`
+	FiredAt        time.Time     `json:"fired_at"`
+	ResolvedAt     *time.Time    `json:"resolved_at,omitempty"`
+	IsNotified     bool          `json:"is_notified"`
+	IsAcknowledged bool          `json:"is_acknowledged"`
+	AcknowledgedBy string        `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time    `json:"acknowledged_at,omitempty"`
+	SnoozedUntil   *time.Time    `json:"snoozed_until,omitempty"`
+	SnoozedBy      string        `json:"snoozed_by,omitempty"`
+	IsEscalated    bool          `json:"is_escalated"`
+	EscalatedAt    *time.Time    `json:"escalated_at,omitempty"`
+	// When the alert escalates if nobody acknowledges it, zero when escalation is disabled
+	escalatesAt time.Time
+}
+
+// Raised by the server when a robot stops sending updates, not by a rule
+const TimeoutAlertName = "timeout"
+
+func (alert *Alert) GetKey() string {
+	return GetAlertKey(alert.RobotId, alert.RuleName)
+}
+
+func GetAlertKey(robotId int, ruleName string) string {
+	return strconv.Itoa(robotId) + "/" + ruleName
+}
+
+// Delivers alerts, e.g. through the Telegram bot.
+// SendAlert is also used to remind about an alert once its snooze is over.
+type AlertNotifier interface {
+	SendAlert(alert Alert)
+	SendAlertResolved(alert Alert)
+	SendAlertEscalated(alert Alert)
+}
+
+// Evaluates the alert rules against robots and keeps track of the alerts they raise.
+// An alert is raised once per rule and robot until its condition stops holding.
+type AlertManager struct {
+	rules  []*AlertRule
+	alerts map[string]*Alert
+	// Last notification per alert key, to apply rules cooldowns across successive alerts
+	lastNotifiedAt map[string]time.Time
+	mutex          sync.Mutex
+	Notifier       AlertNotifier
+	// Notified alerts left unacknowledged for this long are escalated, zero disables escalation
+	EscalationDelay time.Duration
+}
+
+var DefaultAlertRules = []AlertRule{
+	{
+		Name:            "low_battery",
+		Condition:       "battery < 20 and is_charging == 0",
+		Severity:        SeverityWarning,
+		CooldownSeconds: 30 * 60,
+	},
+}
+
+func NewAlertManager(rules []AlertRule) (*AlertManager, error) {
+	manager := &AlertManager{
+		alerts:         make(map[string]*Alert),
+		lastNotifiedAt: make(map[string]time.Time),
+	}
+	return manager, manager.SetRules(rules)
+}
+
+func isReservedAlertName(name string) bool {
+	return name == TimeoutAlertName
+}
+
+func (manager *AlertManager) findRule(name string) (int, *AlertRule) {
+	for i, rule := range manager.rules {
+		if rule.Name == name {
+			return i, rule
+		}
+	}
+	return -1, nil
+}
+
+// Replaces all the rules, which are all checked before any is applied
+func (manager *AlertManager) SetRules(rules []AlertRule) error {
+	compiledRules := make([]*AlertRule, 0, len(rules))
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		rule := rule
+		if err := rule.Compile(); err != nil {
+			return err
+		}
+		if isReservedAlertName(rule.Name) {
+			return errors.New("Alert rule name " + rule.Name + " is reserved")
+		}
+		if names[rule.Name] {
+			return errors.New("Duplicate alert rule " + rule.Name)
+		}
+		names[rule.Name] = true
+		compiledRules = append(compiledRules, &rule)
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.rules = compiledRules
+	manager.dropAlertsWithoutRule()
+	return nil
+}
+
+// Adds the rule, or replaces the rule with the same name
+func (manager *AlertManager) PutRule(rule AlertRule) error {
+	if err := rule.Compile(); err != nil {
+		return err
+	}
+	if isReservedAlertName(rule.Name) {
+		return errors.New("Alert rule name " + rule.Name + " is reserved")
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	index, _ := manager.findRule(rule.Name)
+	if index < 0 {
+		manager.rules = append(manager.rules, &rule)
+	} else {
+		manager.rules[index] = &rule
+	}
+	return nil
+}
+
+func (manager *AlertManager) DeleteRule(name string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	index, _ := manager.findRule(name)
+	if index < 0 {
+		return errors.New("No alert rule named " + name)
+	}
+	manager.rules = append(manager.rules[:index], manager.rules[index+1:]...)
+	manager.dropAlertsWithoutRule()
+	return nil
+}
+
+func (manager *AlertManager) dropAlertsWithoutRule() {
+	for key, alert := range manager.alerts {
+		if isReservedAlertName(alert.RuleName) {
+			continue
+		}
+		if _, rule := manager.findRule(alert.RuleName); rule == nil {
+			delete(manager.alerts, key)
+		}
+	}
+}
+
+func (manager *AlertManager) GetRules() []AlertRule {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	rules := make([]AlertRule, 0, len(manager.rules))
+	for _, rule := range manager.rules {
+		rules = append(rules, *rule)
+	}
+	return rules
+}
+
+// Evaluates every rule against the robot, raising and resolving its alerts.
+// Notifications are sent after releasing the lock, the notifier may be slow.
+func (manager *AlertManager) Evaluate(robot *Robot) {
+	toSend := make([]Alert, 0)
+	toSendResolved := make([]Alert, 0)
+	now := time.Now()
+
+	manager.mutex.Lock()
+	for _, rule := range manager.rules {
+		isTriggered, value := rule.Evaluate(robot)
+		key := GetAlertKey(robot.Id, rule.Name)
+		alert, exists := manager.alerts[key]
+		isActive := exists && alert.IsActive
+
+		if isTriggered && !isActive {
+			alert = &Alert{
+				RobotId:   robot.Id,
+				RuleName:  rule.Name,
+				Condition: rule.Condition,
+				Severity:  rule.Severity,
+				Value:     value,
+				IsActive:  true,
+				FiredAt:   now,
+			}
+			manager.alerts[key] = alert
+			cooldown := time.Duration(rule.CooldownSeconds) * time.Second
+			if lastNotifiedAt, wasNotified := manager.lastNotifiedAt[key]; !wasNotified || now.Sub(lastNotifiedAt) >= cooldown {
+				manager.markNotified(alert, now)
+				toSend = append(toSend, *alert)
+			}
+		} else if isTriggered {
+			alert.Value = value
+		} else if isActive {
+			alert.IsActive = false
+			alert.ResolvedAt = &now
+			if alert.IsNotified {
+				toSendResolved = append(toSendResolved, *alert)
+			}
+		}
+	}
+	manager.mutex.Unlock()
+
+	if manager.Notifier == nil {
+		return
+	}
+	for _, alert := range toSend {
+		manager.Notifier.SendAlert(alert)
+	}
+	for _, alert := range toSendResolved {
+		manager.Notifier.SendAlertResolved(alert)
+	}
+}
+
+func (manager *AlertManager) markNotified(alert *Alert, now time.Time) {
+	alert.IsNotified = true
+	manager.lastNotifiedAt[alert.GetKey()] = now
+	if manager.EscalationDelay > 0 {
+		alert.escalatesAt = now.Add(manager.EscalationDelay)
+	}
+}
+
+// Raises an alert that does not come from a rule, e.g. a robot timeout. It is always notified.
+func (manager *AlertManager) Raise(robotId int, name string, condition string, severity AlertSeverity) {
+	now := time.Now()
+	manager.mutex.Lock()
+	key := GetAlertKey(robotId, name)
+	if alert, exists := manager.alerts[key]; exists && alert.IsActive {
+		manager.mutex.Unlock()
+		return
+	}
+	alert := &Alert{
+		RobotId:   robotId,
+		RuleName:  name,
+		Condition: condition,
+		Severity:  severity,
+		IsActive:  true,
+		FiredAt:   now,
+	}
+	manager.alerts[key] = alert
+	manager.markNotified(alert, now)
+	notifiedAlert := *alert
+	manager.mutex.Unlock()
+
+	if manager.Notifier != nil {
+		manager.Notifier.SendAlert(notifiedAlert)
+	}
+}
+
+// Resolves an alert raised with Raise, does nothing if it is not active
+func (manager *AlertManager) Resolve(robotId int, name string) {
+	now := time.Now()
+	manager.mutex.Lock()
+	alert, exists := manager.alerts[GetAlertKey(robotId, name)]
+	if !exists || !alert.IsActive {
+		manager.mutex.Unlock()
+		return
+	}
+	alert.IsActive = false
+	alert.ResolvedAt = &now
+	resolvedAlert := *alert
+	manager.mutex.Unlock()
+
+	if manager.Notifier != nil && resolvedAlert.IsNotified {
+		manager.Notifier.SendAlertResolved(resolvedAlert)
+	}
+}
+
+// Forgets the alerts of a deleted robot, without notifying them
+func (manager *AlertManager) RemoveRobot(robotId int) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	for key, alert := range manager.alerts {
+		if alert.RobotId == robotId {
+			delete(manager.alerts, key)
+			delete(manager.lastNotifiedAt, key)
+		}
+	}
+}
+
+func (manager *AlertManager) HasActiveAlert(robotId int, ruleName string) bool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	_, err := manager.getActiveAlert(robotId, ruleName)
+	return err == nil
+}
+
+// Returns copies of the alerts, most recent first. Resolved alerts are kept until their rule fires again.
+func (manager *AlertManager) GetAlerts(onlyActive bool) []Alert {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	alerts := make([]Alert, 0, len(manager.alerts))
+	for _, alert := range manager.alerts {
+		if alert.IsActive || !onlyActive {
+			alerts = append(alerts, *alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].FiredAt.After(alerts[j].FiredAt) })
+	return alerts
+}
+
+func (manager *AlertManager) Acknowledge(robotId int, ruleName string, acknowledgedBy string) (Alert, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	alert, err := manager.getActiveAlert(robotId, ruleName)
+	if err != nil {
+		return Alert{}, err
+	}
+	now := time.Now()
+	alert.IsAcknowledged = true
+	alert.AcknowledgedBy = acknowledgedBy
+	alert.AcknowledgedAt = &now
+	alert.SnoozedUntil = nil
+	return *alert, nil
+}
+
+// Returned for an unknown robot and rule pair, or an alert that was resolved
+var ErrNoActiveAlert = errors.New("No active alert")
+
+func (manager *AlertManager) getActiveAlert(robotId int, ruleName string) (*Alert, error) {
+	alert, exists := manager.alerts[GetAlertKey(robotId, ruleName)]
+	if !exists || !alert.IsActive {
+		return nil, fmt.Errorf("%w %s for robot %d", ErrNoActiveAlert, ruleName, robotId)
+	}
+	return alert, nil
+}
+
+// Silences an unacknowledged alert : it is notified again once the snooze is over,
+// and only escalates after a full escalation delay from then
+func (manager *AlertManager) Snooze(robotId int, ruleName string, duration time.Duration, snoozedBy string) (Alert, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	alert, err := manager.getActiveAlert(robotId, ruleName)
+	if err != nil {
+		return Alert{}, err
+	}
+	if alert.IsAcknowledged {
+		return Alert{}, errors.New("Alert " + ruleName + " for robot " + strconv.Itoa(robotId) + " is already acknowledged")
+	}
+	snoozedUntil := time.Now().Add(duration)
+	alert.SnoozedUntil = &snoozedUntil
+	alert.SnoozedBy = snoozedBy
+	if manager.EscalationDelay > 0 {
+		alert.escalatesAt = snoozedUntil.Add(manager.EscalationDelay)
+	}
+	return *alert, nil
+}
+
+// Sends reminders for alerts whose snooze is over and escalates unacknowledged alerts
+func (manager *AlertManager) checkTimers(now time.Time) {
+	toRemind := make([]Alert, 0)
+	toEscalate := make([]Alert, 0)
+
+	manager.mutex.Lock()
+	for _, alert := range manager.alerts {
+		if !alert.IsActive || !alert.IsNotified || alert.IsAcknowledged {
+			continue
+		}
+		if alert.SnoozedUntil != nil {
+			if now.Before(*alert.SnoozedUntil) {
+				continue
+			}
+			alert.SnoozedUntil = nil
+			toRemind = append(toRemind, *alert)
+		}
+		if !alert.IsEscalated && !alert.escalatesAt.IsZero() && !now.Before(alert.escalatesAt) {
+			alert.IsEscalated = true
+			alert.EscalatedAt = &now
+			toEscalate = append(toEscalate, *alert)
+		}
+	}
+	manager.mutex.Unlock()
+
+	if manager.Notifier == nil {
+		return
+	}
+	for _, alert := range toRemind {
+		manager.Notifier.SendAlert(alert)
+	}
+	for _, alert := range toEscalate {
+		manager.Notifier.SendAlertEscalated(alert)
+	}
+}
+
+// Checks snoozes and escalations every interval, never returns
+func (manager *AlertManager) WatchTimers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for now := range ticker.C {
+		manager.checkTimers(now)
+	}
+}
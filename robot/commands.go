@@ -0,0 +1,389 @@
+package robot
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CommandType string
+
+const (
+	CommandPause             CommandType = "pause"
+	CommandResume            CommandType = "resume"
+	CommandAbort             CommandType = "abort"
+	CommandReturnToBase      CommandType = "return_to_base"
+	CommandSkipWaypoint      CommandType = "skip_waypoint"
+	CommandSetWaypoints      CommandType = "set_waypoints"
+	CommandSetReportInterval CommandType = "set_report_interval"
+)
+
+var commandTypes = []CommandType{
+	CommandPause, CommandResume, CommandAbort, CommandReturnToBase,
+	CommandSkipWaypoint, CommandSetWaypoints, CommandSetReportInterval,
+}
+
+func ParseCommandType(name string) (CommandType, error) {
+	for _, commandType := range commandTypes {
+		if string(commandType) == name {
+			return commandType, nil
+		}
+	}
+	names := make([]string, 0, len(commandTypes))
+	for _, commandType := range commandTypes {
+		names = append(names, string(commandType))
+	}
+	return "", errors.New("Unknown command " + name + ", known commands are " + strings.Join(names, ", "))
+}
+
+// Commands are pending until a robot update or poll takes them, then wait for the robot to acknowledge them.
+// Pending commands expire if no robot took them in time, delivered ones if the robot did not acknowledge them in time,
+// e.g. because the response or the status carrying the acknowledgement was lost.
+type CommandState string
+
+const (
+	CommandStatePending      CommandState = "pending"
+	CommandStateDelivered    CommandState = "delivered"
+	CommandStateAcknowledged CommandState = "acknowledged"
+	CommandStateFailed       CommandState = "failed"
+	CommandStateExpired      CommandState = "expired"
+)
+
+const DefaultCommandTtlSeconds = 600
+
+// Robots acknowledge a command in the status following its delivery
+const CommandAcknowledgementTimeoutSeconds = 300
+
+type Waypoint struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+}
+
+// What an operator asks for, the queue turns it into a RobotCommand
+type CommandRequest struct {
+	Type CommandType `json:"type"`
+	// Only for set_waypoints, replacing the remaining waypoints of the mission
+	Waypoints []Waypoint `json:"waypoints,omitempty"`
+	// Only for set_report_interval
+	ReportIntervalSeconds int `json:"report_interval_seconds,omitempty"`
+	// 0 uses DefaultCommandTtlSeconds
+	TtlSeconds int    `json:"ttl_seconds,omitempty"`
+	IssuedBy   string `json:"issued_by"`
+}
+
+func (request *CommandRequest) Validate() error {
+	if _, err := ParseCommandType(string(request.Type)); err != nil {
+		return err
+	}
+	if request.TtlSeconds < 0 {
+		return errors.New("Command TTL must be positive")
+	}
+	if request.Type == CommandSetWaypoints && len(request.Waypoints) == 0 {
+		return errors.New("set_waypoints needs at least one waypoint")
+	}
+	if request.Type != CommandSetWaypoints && len(request.Waypoints) > 0 {
+		return errors.New("Only set_waypoints takes waypoints")
+	}
+	if request.Type == CommandSetReportInterval && request.ReportIntervalSeconds <= 0 {
+		return errors.New("set_report_interval needs a strictly positive report_interval_seconds")
+	}
+	if request.Type != CommandSetReportInterval && request.ReportIntervalSeconds != 0 {
+		return errors.New("Only set_report_interval takes report_interval_seconds")
+	}
+	return nil
+}
+
+type RobotCommand struct {
+	Id                    int          `json:"id"`
+	RobotId               int          `json:"robot_id"`
+	Type                  CommandType  `json:"type"`
+	Waypoints             []Waypoint   `json:"waypoints,omitempty"`
+	ReportIntervalSeconds int          `json:"report_interval_seconds,omitempty"`
+	State                 CommandState `json:"state"`
+	IssuedBy              string       `json:"issued_by"`
+	IssuedAt              time.Time    `json:"issued_at"`
+	ExpiresAt             time.Time    `json:"expires_at"`
+	DeliveredAt           *time.Time   `json:"delivered_at,omitempty"`
+	AcknowledgeBy         *time.Time   `json:"acknowledge_by,omitempty"`
+	AcknowledgedAt        *time.Time   `json:"acknowledged_at,omitempty"`
+	// Reported by the robot when it could not execute the command
+	Error string `json:"error,omitempty"`
+}
+
+func (command *RobotCommand) IsFinished() bool {
+	return command.State == CommandStateAcknowledged || command.State == CommandStateFailed ||
+		command.State == CommandStateExpired
+}
+
+// Sent by robots in the status following the delivery of a command
+type CommandAcknowledgement struct {
+	CommandId    int    `json:"command_id"`
+	IsSuccessful bool   `json:"is_successful"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Response of the server to a robot update
+type UpdateResponse struct {
+	// nil until the robot sent at least one update after registering
+	Kinematics *Kinematics    `json:"kinematics"`
+	Commands   []RobotCommand `json:"commands,omitempty"`
+}
+
+// Reports the progress of the commands, e.g. to the Telegram chat that issued them
+type CommandNotifier interface {
+	SendCommandUpdate(command RobotCommand)
+}
+
+// Finished commands kept per robot to inspect them
+const MaxFinishedCommandsPerRobot = 50
+
+// Queues the commands of every robot until their next update or poll
+type CommandQueue struct {
+	commandsByRobot map[int][]*RobotCommand
+	// Closed when a command is queued for the robot, to wake up its pollers
+	pendingSignals map[int]chan struct{}
+	nextCommandId  int
+	mutex          sync.Mutex
+	Notifier       CommandNotifier
+}
+
+func NewCommandQueue() *CommandQueue {
+	return &CommandQueue{
+		commandsByRobot: make(map[int][]*RobotCommand),
+		pendingSignals:  make(map[int]chan struct{}),
+	}
+}
+
+func (queue *CommandQueue) notify(commands []RobotCommand) {
+	if queue.Notifier == nil {
+		return
+	}
+	for _, command := range commands {
+		queue.Notifier.SendCommandUpdate(command)
+	}
+}
+
+func (queue *CommandQueue) Enqueue(robotId int, request CommandRequest) (RobotCommand, error) {
+	if err := request.Validate(); err != nil {
+		return RobotCommand{}, err
+	}
+	ttlSeconds := request.TtlSeconds
+	if ttlSeconds == 0 {
+		ttlSeconds = DefaultCommandTtlSeconds
+	}
+	now := time.Now()
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.nextCommandId++
+	command := &RobotCommand{
+		Id:                    queue.nextCommandId,
+		RobotId:               robotId,
+		Type:                  request.Type,
+		Waypoints:             request.Waypoints,
+		ReportIntervalSeconds: request.ReportIntervalSeconds,
+		State:                 CommandStatePending,
+		IssuedBy:              request.IssuedBy,
+		IssuedAt:              now,
+		ExpiresAt:             now.Add(time.Duration(ttlSeconds) * time.Second),
+	}
+	queue.commandsByRobot[robotId] = append(queue.commandsByRobot[robotId], command)
+	if signal, hasPollers := queue.pendingSignals[robotId]; hasPollers {
+		close(signal)
+		delete(queue.pendingSignals, robotId)
+	}
+	return *command, nil
+}
+
+// Expires the pending commands of the robot past their expiry and the delivered ones past their acknowledgement
+// deadline, the queue mutex must be held
+func (queue *CommandQueue) expireCommands(robotId int, now time.Time) []RobotCommand {
+	expired := make([]RobotCommand, 0)
+	for _, command := range queue.commandsByRobot[robotId] {
+		switch {
+		case command.State == CommandStatePending && !now.Before(command.ExpiresAt):
+			command.Error = "Not delivered before " + command.ExpiresAt.Format(time.DateTime)
+		case command.State == CommandStateDelivered && !now.Before(*command.AcknowledgeBy):
+			command.Error = "Not acknowledged before " + command.AcknowledgeBy.Format(time.DateTime)
+		default:
+			continue
+		}
+		command.State = CommandStateExpired
+		expired = append(expired, *command)
+	}
+	if len(expired) > 0 {
+		queue.dropOldFinishedCommands(robotId)
+	}
+	return expired
+}
+
+// Expires the commands of every robot, so that offline robots do not get them once back
+// and the issuers learn about the commands that were never acknowledged
+func (queue *CommandQueue) ExpireCommands(now time.Time) {
+	expired := make([]RobotCommand, 0)
+	queue.mutex.Lock()
+	for robotId := range queue.commandsByRobot {
+		expired = append(expired, queue.expireCommands(robotId, now)...)
+	}
+	queue.mutex.Unlock()
+	queue.notify(expired)
+}
+
+func (queue *CommandQueue) WatchExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for now := range ticker.C {
+		queue.ExpireCommands(now)
+	}
+}
+
+// Marks the pending commands of the robot as delivered and returns them, oldest first
+func (queue *CommandQueue) TakePending(robotId int) []RobotCommand {
+	queue.mutex.Lock()
+	expired, delivered := queue.takePending(robotId, time.Now())
+	queue.mutex.Unlock()
+
+	queue.notify(expired)
+	queue.notify(delivered)
+	return delivered
+}
+
+// The queue mutex must be held
+func (queue *CommandQueue) takePending(robotId int, now time.Time) ([]RobotCommand, []RobotCommand) {
+	expired := queue.expireCommands(robotId, now)
+	delivered := make([]RobotCommand, 0)
+	acknowledgeBy := now.Add(CommandAcknowledgementTimeoutSeconds * time.Second)
+	for _, command := range queue.commandsByRobot[robotId] {
+		if command.State == CommandStatePending {
+			command.State = CommandStateDelivered
+			command.DeliveredAt = &now
+			command.AcknowledgeBy = &acknowledgeBy
+			delivered = append(delivered, *command)
+		}
+	}
+	return expired, delivered
+}
+
+// Long poll for robots that want their commands before their next update :
+// waits until commands are pending for the robot or the timeout passed, then takes them
+func (queue *CommandQueue) WaitPending(robotId int, timeout time.Duration) []RobotCommand {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		queue.mutex.Lock()
+		expired, delivered := queue.takePending(robotId, time.Now())
+		signal, hasPollers := queue.pendingSignals[robotId]
+		if len(delivered) == 0 && !hasPollers {
+			signal = make(chan struct{})
+			queue.pendingSignals[robotId] = signal
+		}
+		queue.mutex.Unlock()
+
+		queue.notify(expired)
+		if len(delivered) > 0 {
+			queue.notify(delivered)
+			return delivered
+		}
+		select {
+		case <-signal:
+		case <-timer.C:
+			return delivered
+		}
+	}
+}
+
+func (queue *CommandQueue) Acknowledge(robotId int, acknowledgement CommandAcknowledgement) error {
+	now := time.Now()
+	queue.mutex.Lock()
+	var acknowledged *RobotCommand
+	for _, command := range queue.commandsByRobot[robotId] {
+		if command.Id == acknowledgement.CommandId {
+			acknowledged = command
+			break
+		}
+	}
+	// Robots send their acknowledgements again until a status carrying them gets through
+	if acknowledged != nil && acknowledged.AcknowledgedAt != nil {
+		queue.mutex.Unlock()
+		return nil
+	}
+	if acknowledged == nil || acknowledged.State != CommandStateDelivered {
+		queue.mutex.Unlock()
+		return errors.New("Robot " + strconv.Itoa(robotId) + " has no delivered command " + strconv.Itoa(acknowledgement.CommandId))
+	}
+	acknowledged.AcknowledgedAt = &now
+	if acknowledgement.IsSuccessful {
+		acknowledged.State = CommandStateAcknowledged
+	} else {
+		acknowledged.State = CommandStateFailed
+		acknowledged.Error = acknowledgement.Error
+	}
+	command := *acknowledged
+	queue.dropOldFinishedCommands(robotId)
+	queue.mutex.Unlock()
+
+	queue.notify([]RobotCommand{command})
+	return nil
+}
+
+func (queue *CommandQueue) dropOldFinishedCommands(robotId int) {
+	commands := queue.commandsByRobot[robotId]
+	finishedCount := 0
+	for _, command := range commands {
+		if command.IsFinished() {
+			finishedCount++
+		}
+	}
+	kept := make([]*RobotCommand, 0, len(commands))
+	for _, command := range commands {
+		if command.IsFinished() && finishedCount > MaxFinishedCommandsPerRobot {
+			finishedCount--
+			continue
+		}
+		kept = append(kept, command)
+	}
+	queue.commandsByRobot[robotId] = kept
+}
+
+// Expires the unfinished commands of a deleted robot, so that their issuers learn about it, then forgets its commands
+func (queue *CommandQueue) RemoveRobot(robotId int) {
+	queue.mutex.Lock()
+	expired := make([]RobotCommand, 0)
+	for _, command := range queue.commandsByRobot[robotId] {
+		if !command.IsFinished() {
+			command.State = CommandStateExpired
+			command.Error = "Robot " + strconv.Itoa(robotId) + " was deleted"
+			expired = append(expired, *command)
+		}
+	}
+	delete(queue.commandsByRobot, robotId)
+	if signal, hasPollers := queue.pendingSignals[robotId]; hasPollers {
+		close(signal)
+		delete(queue.pendingSignals, robotId)
+	}
+	queue.mutex.Unlock()
+	queue.notify(expired)
+}
+
+func (queue *CommandQueue) GetCommand(robotId int, commandId int) (RobotCommand, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	for _, command := range queue.commandsByRobot[robotId] {
+		if command.Id == commandId {
+			return *command, nil
+		}
+	}
+	return RobotCommand{}, errors.New("Robot " + strconv.Itoa(robotId) + " has no command " + strconv.Itoa(commandId))
+}
+
+// Copies of the commands of the robot, oldest first
+func (queue *CommandQueue) GetCommands(robotId int) []RobotCommand {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	commands := make([]RobotCommand, 0, len(queue.commandsByRobot[robotId]))
+	for _, command := range queue.commandsByRobot[robotId] {
+		commands = append(commands, *command)
+	}
+	return commands
+}
@@ -0,0 +1,330 @@
+package robot
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"paltech.robot/robot/geo"
+)
+
+// Longer jumps between two statuses are relocations or positioning glitches, not worked ground
+const MaxCoverageSegmentMeters = 1000
+
+type CoverageSettings struct {
+	ToolWidthMeters float64
+	CellSizeMeters  float64
+	// Coverage is also reported as a share of the garden area when it is set
+	GardenArea *GardenArea
+}
+
+// Square cell of the grid, counted in cells east and north of the grid origin
+type CoverageCell struct {
+	East   int
+	North  int
+	Passes int
+}
+
+type cellKey struct {
+	east  int
+	north int
+}
+
+type cellPasses struct {
+	passes int
+	// Last path segment over the cell, so that joints between segments do not count as a second pass
+	lastSegment int
+}
+
+// Number of tool passes over every cell of a grid laid on the local tangent plane of its origin
+type CoverageGrid struct {
+	Origin         geo.Point
+	CellSizeMeters float64
+	cells          map[cellKey]cellPasses
+}
+
+// Area worked by a robot, JSON encoded by the API
+type Coverage struct {
+	ToolWidthMeters         float64 `json:"tool_width_meters"`
+	CellSizeMeters          float64 `json:"cell_size_meters"`
+	CoveredAreaSquareMeters float64 `json:"covered_area_m2"`
+	// Area the tool passed over at least twice
+	OverlapAreaSquareMeters float64 `json:"overlap_area_m2"`
+	MaxPasses               int     `json:"max_passes"`
+	GardenName              string  `json:"garden_name,omitempty"`
+	GardenAreaSquareMeters  float64 `json:"garden_area_m2,omitempty"`
+	// Share of the garden area covered, between 0 and 1
+	GardenCoveredRatio float64 `json:"garden_covered_ratio,omitempty"`
+}
+
+// Robots only work the ground on their mission, not while idle, charging or returning to base.
+// Robots that do not report their task are assumed to be working.
+func (robotStatus *RobotStatus) IsWorking() bool {
+	if !robotStatus.HasTelemetry() {
+		return true
+	}
+	switch robotStatus.Telemetry.CurrentTask {
+	case TaskIdle, TaskCharging, TaskReturningToBase:
+		return false
+	}
+	return true
+}
+
+func NewCoverageGrid(origin geo.Point, cellSizeMeters float64) *CoverageGrid {
+	return &CoverageGrid{
+		Origin:         origin,
+		CellSizeMeters: cellSizeMeters,
+		cells:          make(map[cellKey]cellPasses),
+	}
+}
+
+// Narrows [lower, upper] to the x where coefficient * x + offset is within [minValue, maxValue]
+func clipInterval(lower float64, upper float64, coefficient float64, offset float64, minValue float64, maxValue float64) (float64, float64) {
+	if math.Abs(coefficient) < 1e-12 {
+		if offset < minValue || offset > maxValue {
+			return math.Inf(1), math.Inf(-1)
+		}
+		return lower, upper
+	}
+	bound1, bound2 := (minValue-offset)/coefficient, (maxValue-offset)/coefficient
+	return math.Max(lower, math.Min(bound1, bound2)), math.Min(upper, math.Max(bound1, bound2))
+}
+
+// East interval of the segment buffered by radius on the line at north, empty when the minimum is above the maximum.
+// The buffer is the union of a disc around each end and of a band along the segment, and is convex.
+func getBufferRowInterval(north float64, fromEast float64, fromNorth float64, toEast float64, toNorth float64, radius float64) (float64, float64) {
+	minEast, maxEast := math.Inf(1), math.Inf(-1)
+	extend := func(lower float64, upper float64) {
+		if lower <= upper {
+			minEast, maxEast = math.Min(minEast, lower), math.Max(maxEast, upper)
+		}
+	}
+	for _, end := range [][2]float64{{fromEast, fromNorth}, {toEast, toNorth}} {
+		if deltaNorth := north - end[1]; math.Abs(deltaNorth) <= radius {
+			halfChord := math.Sqrt(radius*radius - deltaNorth*deltaNorth)
+			extend(end[0]-halfChord, end[0]+halfChord)
+		}
+	}
+
+	length := math.Hypot(toEast-fromEast, toNorth-fromNorth)
+	if length > 0 {
+		// Along and across the segment coordinates of (fromEast + x, north) are linear in x
+		unitEast, unitNorth := (toEast-fromEast)/length, (toNorth-fromNorth)/length
+		lower, upper := clipInterval(math.Inf(-1), math.Inf(1), unitEast, (north-fromNorth)*unitNorth, 0, length)
+		lower, upper = clipInterval(lower, upper, -unitNorth, (north-fromNorth)*unitEast, -radius, radius)
+		extend(fromEast+lower, fromEast+upper)
+	}
+	return minEast, maxEast
+}
+
+// Marks the cells whose center is within halfWidth of the segment, row by row
+func (grid *CoverageGrid) addSegment(from geo.Point, to geo.Point, halfWidth float64, segment int) {
+	fromEast, fromNorth := geo.ToENU(grid.Origin, from)
+	toEast, toNorth := geo.ToENU(grid.Origin, to)
+	cellSize := grid.CellSizeMeters
+	southRow := int(math.Floor((math.Min(fromNorth, toNorth) - halfWidth) / cellSize))
+	northRow := int(math.Ceil((math.Max(fromNorth, toNorth) + halfWidth) / cellSize))
+
+	for north := southRow; north <= northRow; north++ {
+		centerNorth := (float64(north) + 0.5) * cellSize
+		minEast, maxEast := getBufferRowInterval(centerNorth, fromEast, fromNorth, toEast, toNorth, halfWidth)
+		if minEast > maxEast {
+			continue
+		}
+		for east := int(math.Ceil(minEast/cellSize - 0.5)); east <= int(math.Floor(maxEast/cellSize-0.5)); east++ {
+			key := cellKey{east, north}
+			cell, isKnown := grid.cells[key]
+			centerEast := (float64(east) + 0.5) * cellSize
+			switch {
+			case !isKnown:
+				cell.passes = 1
+			case cell.lastSegment == segment-1 && math.Hypot(centerEast-fromEast, centerNorth-fromNorth) <= halfWidth:
+				// Around the joint with the previous segment, the tool is still on the same pass
+			default:
+				cell.passes++
+			}
+			cell.lastSegment = segment
+			grid.cells[key] = cell
+		}
+	}
+}
+
+// Buffers the path by half the tool width on each side, skipping the segments the robot was not working on.
+// Segments ending before firstStatus are skipped too, e.g. when they were added by a previous call.
+func (grid *CoverageGrid) AddPath(statuses []*RobotStatus, firstStatus int, toolWidthMeters float64) {
+	if firstStatus < 1 {
+		firstStatus = 1
+	}
+	for i := firstStatus; i < len(statuses); i++ {
+		from, to := statuses[i-1].GetPosition(), statuses[i].GetPosition()
+		if !statuses[i-1].IsWorking() || geo.Distance(from, to) > MaxCoverageSegmentMeters {
+			continue
+		}
+		grid.addSegment(from, to, toolWidthMeters/2, i)
+	}
+}
+
+func (grid *CoverageGrid) clone() *CoverageGrid {
+	clone := NewCoverageGrid(grid.Origin, grid.CellSizeMeters)
+	for key, cell := range grid.cells {
+		clone.cells[key] = cell
+	}
+	return clone
+}
+
+type cachedCoverageGrid struct {
+	grid *CoverageGrid
+	// Number of statuses of the robot history added to the grid
+	statusCount int
+}
+
+// Coverage grids of the robots, extended with the new statuses of a robot instead of being computed from its whole history
+type CoverageCache struct {
+	Settings *CoverageSettings
+	grids    map[int]*cachedCoverageGrid
+	mutex    sync.Mutex
+}
+
+func NewCoverageCache(settings *CoverageSettings) *CoverageCache {
+	return &CoverageCache{
+		Settings: settings,
+		grids:    make(map[int]*cachedCoverageGrid),
+	}
+}
+
+// Copy of the grid of the robot path, aligned on the south west corner of the garden when there is one.
+// Status histories only grow, so a robot copied before the grid was last extended gets the more recent grid.
+func (cache *CoverageCache) GetGrid(robot *Robot) *CoverageGrid {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cached, isCached := cache.grids[robot.Id]
+	if !isCached {
+		origin := geo.Point{}
+		if cache.Settings.GardenArea != nil {
+			origin = cache.Settings.GardenArea.GetCorners()[0]
+		} else if len(robot.StatusHistory) > 0 {
+			origin = robot.StatusHistory[0].GetPosition()
+		} else {
+			// The origin is only known with the first status
+			return NewCoverageGrid(origin, cache.Settings.CellSizeMeters)
+		}
+		cached = &cachedCoverageGrid{grid: NewCoverageGrid(origin, cache.Settings.CellSizeMeters)}
+		cache.grids[robot.Id] = cached
+	}
+	if len(robot.StatusHistory) > cached.statusCount {
+		cached.grid.AddPath(robot.StatusHistory, cached.statusCount, cache.Settings.ToolWidthMeters)
+		cached.statusCount = len(robot.StatusHistory)
+	}
+	return cached.grid.clone()
+}
+
+func (cache *CoverageCache) GetCoverage(robot *Robot) Coverage {
+	return cache.GetGrid(robot).GetCoverage(cache.Settings)
+}
+
+// Forgets the grid of a deleted robot
+func (cache *CoverageCache) RemoveRobot(robotId int) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.grids, robotId)
+}
+
+func (grid *CoverageGrid) IsEmpty() bool {
+	return len(grid.cells) == 0
+}
+
+// Covered cells from south west to north east
+func (grid *CoverageGrid) GetCells() []CoverageCell {
+	cells := make([]CoverageCell, 0, len(grid.cells))
+	for key, cell := range grid.cells {
+		cells = append(cells, CoverageCell{East: key.east, North: key.north, Passes: cell.passes})
+	}
+	sort.Slice(cells, func(i int, j int) bool {
+		if cells[i].North != cells[j].North {
+			return cells[i].North < cells[j].North
+		}
+		return cells[i].East < cells[j].East
+	})
+	return cells
+}
+
+func (grid *CoverageGrid) GetCellCenter(cell CoverageCell) geo.Point {
+	return geo.FromENU(grid.Origin, (float64(cell.East)+0.5)*grid.CellSizeMeters, (float64(cell.North)+0.5)*grid.CellSizeMeters)
+}
+
+// South west corner first, then counterclockwise
+func (grid *CoverageGrid) GetCellCorners(cell CoverageCell) []geo.Point {
+	west, south := float64(cell.East)*grid.CellSizeMeters, float64(cell.North)*grid.CellSizeMeters
+	east, north := west+grid.CellSizeMeters, south+grid.CellSizeMeters
+	return []geo.Point{
+		geo.FromENU(grid.Origin, west, south),
+		geo.FromENU(grid.Origin, east, south),
+		geo.FromENU(grid.Origin, east, north),
+		geo.FromENU(grid.Origin, west, north),
+	}
+}
+
+func (grid *CoverageGrid) GetCoverage(settings *CoverageSettings) Coverage {
+	coverage := Coverage{
+		ToolWidthMeters: settings.ToolWidthMeters,
+		CellSizeMeters:  grid.CellSizeMeters,
+	}
+	cellArea := grid.CellSizeMeters * grid.CellSizeMeters
+	gardenCoveredArea := 0.0
+	for key, cell := range grid.cells {
+		coverage.CoveredAreaSquareMeters += cellArea
+		if cell.passes > 1 {
+			coverage.OverlapAreaSquareMeters += cellArea
+		}
+		if cell.passes > coverage.MaxPasses {
+			coverage.MaxPasses = cell.passes
+		}
+		if settings.GardenArea != nil && settings.GardenArea.Contains(grid.GetCellCenter(CoverageCell{East: key.east, North: key.north})) {
+			gardenCoveredArea += cellArea
+		}
+	}
+
+	if settings.GardenArea != nil {
+		coverage.GardenName = settings.GardenArea.Name
+		coverage.GardenAreaSquareMeters = settings.GardenArea.GetAreaSquareMeters()
+		if coverage.GardenAreaSquareMeters > 0 {
+			coverage.GardenCoveredRatio = math.Min(1, gardenCoveredArea/coverage.GardenAreaSquareMeters)
+		}
+	}
+	return coverage
+}
+
+type GeoJSONGeometry struct {
+	Type string `json:"type"`
+	// Rings of [longitude, latitude] positions, the first and last positions of a ring are the same
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// One square polygon per covered cell, with its pass count as the "passes" property
+func (grid *CoverageGrid) ToGeoJSON() GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]GeoJSONFeature, 0, len(grid.cells))}
+	for _, cell := range grid.GetCells() {
+		ring := make([][2]float64, 0, 5)
+		for _, corner := range grid.GetCellCorners(cell) {
+			ring = append(ring, [2]float64{corner.Longitude, corner.Latitude})
+		}
+		ring = append(ring, ring[0])
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type:       "Feature",
+			Geometry:   GeoJSONGeometry{Type: "Polygon", Coordinates: [][][2]float64{ring}},
+			Properties: map[string]interface{}{"passes": cell.Passes},
+		})
+	}
+	return collection
+}
@@ -0,0 +1,45 @@
+package robot
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"paltech.robot/robot/geo"
+)
+
+// Extending the cached grid a few statuses at a time must give the grid of the whole path
+func TestCoverageCacheMatchesWholePath(t *testing.T) {
+	settings := &CoverageSettings{ToolWidthMeters: 1.5, CellSizeMeters: 0.5}
+	origin := geo.NewPoint(47.5, 9.7)
+	robot := &Robot{Id: 3}
+	cache := NewCoverageCache(settings)
+	if !cache.GetGrid(robot).IsEmpty() {
+		t.Fatal("Expected an empty grid before the first status")
+	}
+
+	for i := 0; i < 300; i++ {
+		status := &RobotStatus{Timestamp: int64(i)}
+		// Crosses itself, so that cells get several passes
+		status.SetPosition(geo.FromENU(origin, 20*math.Cos(float64(i)/10), 15*math.Sin(float64(i)/7)))
+		robot.AppendStatus(status)
+		if i%7 == 0 {
+			cache.GetGrid(robot)
+		}
+	}
+	cached := cache.GetGrid(robot)
+	whole := NewCoverageGrid(robot.StatusHistory[0].GetPosition(), settings.CellSizeMeters)
+	whole.AddPath(robot.StatusHistory, 0, settings.ToolWidthMeters)
+	if !reflect.DeepEqual(cached.GetCells(), whole.GetCells()) {
+		t.Fatal("The cached grid differs from the grid of the whole path")
+	}
+	if coverage := cache.GetCoverage(robot); coverage.MaxPasses < 2 {
+		t.Errorf("Expected overlapping passes, got %+v", coverage)
+	}
+
+	cache.RemoveRobot(robot.Id)
+	robot.StatusHistory = robot.StatusHistory[:1]
+	if !cache.GetGrid(robot).IsEmpty() {
+		t.Error("Expected the grid of a removed robot to be computed again")
+	}
+}
@@ -0,0 +1,68 @@
+package robot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Colours known by the static maps API, so that legends can name them
+var PathColors = []string{"red", "blue", "green", "orange", "purple", "brown", "yellow", "gray", "black"}
+
+// Hue step between two generated colours, the golden angle keeps consecutive ids far apart on the colour wheel.
+// The first generated hue is cyan, which none of the named colours is.
+const generatedPathHueStepDegrees = 137.508
+const firstGeneratedPathHueDegrees = 185
+
+// Named colour of the first robots, then a generated 0xRRGGBB colour that the static maps API also takes
+func GetPathColor(robotId int) string {
+	if robotId >= 0 && robotId < len(PathColors) {
+		return PathColors[robotId]
+	}
+	index := robotId - len(PathColors)
+	hue := math.Mod(firstGeneratedPathHueDegrees+float64(index)*generatedPathHueStepDegrees, 360)
+	// Alternating brightness tells apart ids whose hues end up close
+	value := 0.85
+	if index%2 == 1 {
+		value = 0.55
+	}
+	red, green, blue := hsvToRgb(hue, 0.8, value)
+	return fmt.Sprintf("0x%02x%02x%02x", red, green, blue)
+}
+
+// Hue in degrees, saturation and value between 0 and 1
+func hsvToRgb(hue float64, saturation float64, value float64) (uint8, uint8, uint8) {
+	chroma := value * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	var red, green, blue float64
+	switch {
+	case hue < 60:
+		red, green = chroma, x
+	case hue < 120:
+		red, green = x, chroma
+	case hue < 180:
+		green, blue = chroma, x
+	case hue < 240:
+		green, blue = x, chroma
+	case hue < 300:
+		red, blue = x, chroma
+	default:
+		red, blue = chroma, x
+	}
+	toByte := func(component float64) uint8 {
+		return uint8(math.Round((component + value - chroma) * 255))
+	}
+	return toByte(red), toByte(green), toByte(blue)
+}
+
+// Map without any overlay, e.g. as the background of the fleet maps
+func (options *StaticMapOptions) GetBaseUrl() string {
+	size := strconv.Itoa(options.SizePixels)
+	url := "https://maps.googleapis.com/maps/api/staticmap?size=" + size + "x" + size
+	if options.Zoom > 0 {
+		url += "&zoom=" + strconv.Itoa(options.Zoom)
+		url += "&center=" + strconv.FormatFloat(options.CenterLatitude, 'f', 6, 64)
+		url += "," + strconv.FormatFloat(options.CenterLongitude, 'f', 6, 64)
+	}
+	return url
+}
@@ -0,0 +1,66 @@
+package robot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"paltech.robot/robot/geo"
+)
+
+// Field the robots work in, shared by the simulator that drives them and the server that measures their coverage
+type GardenArea struct {
+	Name         string  `json:"name"`
+	MinLatitude  float64 `json:"min_lat"`
+	MaxLatitude  float64 `json:"max_lat"`
+	MinLongitude float64 `json:"min_lon"`
+	MaxLongitude float64 `json:"max_lon"`
+}
+
+// https://maps.google.com/?q=<lat>,<lng>
+var DefaultGardenArea = &GardenArea{
+	Name:         "Südliche Fröttmaninger Heide",
+	MinLatitude:  48.210965,
+	MaxLatitude:  48.224528,
+	MinLongitude: 11.599042,
+	MaxLongitude: 11.614783,
+}
+
+func LoadGardenArea(filepath string) (*GardenArea, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	area := new(GardenArea)
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(area); err != nil {
+		return nil, fmt.Errorf("Could not parse garden area %s : %w", filepath, err)
+	}
+	if area.MinLatitude >= area.MaxLatitude || area.MinLongitude >= area.MaxLongitude {
+		return nil, errors.New("Garden area minimum coordinates must be lower than maximum coordinates")
+	}
+	return area, nil
+}
+
+func (area *GardenArea) Contains(point geo.Point) bool {
+	return point.Latitude >= area.MinLatitude && point.Latitude <= area.MaxLatitude &&
+		point.Longitude >= area.MinLongitude && point.Longitude <= area.MaxLongitude
+}
+
+// South west corner first, then counterclockwise
+func (area *GardenArea) GetCorners() []geo.Point {
+	return []geo.Point{
+		geo.NewPoint(area.MinLatitude, area.MinLongitude),
+		geo.NewPoint(area.MinLatitude, area.MaxLongitude),
+		geo.NewPoint(area.MaxLatitude, area.MaxLongitude),
+		geo.NewPoint(area.MaxLatitude, area.MinLongitude),
+	}
+}
+
+func (area *GardenArea) GetAreaSquareMeters() float64 {
+	return geo.PolygonArea(area.GetCorners())
+}
@@ -0,0 +1,189 @@
+package geo
+
+import (
+	"math"
+)
+
+// WGS84 ellipsoid parameters
+const EarthEquatorialRadiusMeters float64 = 6378137
+const EarthFlattening float64 = 1 / 298.257223563
+const EarthPolarRadiusMeters float64 = EarthEquatorialRadiusMeters * (1 - EarthFlattening)
+const EarthMeanRadiusMeters float64 = 6371008.8
+
+const vincentyMaxIterations = 200
+const vincentyConvergenceThreshold float64 = 1e-12
+
+type Point struct {
+	Latitude  float64
+	Longitude float64
+}
+
+func NewPoint(latitude float64, longitude float64) Point {
+	return Point{Latitude: latitude, Longitude: longitude}
+}
+
+func DegreesToRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func RadiansToDegrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
+
+// Keeps a longitude in the [-180, 180[ range
+func normalizeLongitude(longitude float64) float64 {
+	return math.Mod(math.Mod(longitude+180, 360)+360, 360) - 180
+}
+
+// Keeps a bearing in the [0, 360[ range
+func normalizeBearing(bearing float64) float64 {
+	return math.Mod(math.Mod(bearing, 360)+360, 360)
+}
+
+// Great circle distance in meters on a spherical earth, good to about 0.5%
+func HaversineDistance(from Point, to Point) float64 {
+	lat1 := DegreesToRadians(from.Latitude)
+	lat2 := DegreesToRadians(to.Latitude)
+	deltaLat := lat2 - lat1
+	deltaLon := DegreesToRadians(to.Longitude - from.Longitude)
+
+	a := math.Pow(math.Sin(deltaLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(deltaLon/2), 2)
+	return 2 * EarthMeanRadiusMeters * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Geodesic distance in meters on the WGS84 ellipsoid using Vincenty's inverse formula.
+// Falls back to the haversine distance for nearly antipodal points where the formula does not converge.
+func VincentyDistance(from Point, to Point) float64 {
+	a := EarthEquatorialRadiusMeters
+	b := EarthPolarRadiusMeters
+	f := EarthFlattening
+
+	L := DegreesToRadians(to.Longitude - from.Longitude)
+	U1 := math.Atan((1 - f) * math.Tan(DegreesToRadians(from.Latitude)))
+	U2 := math.Atan((1 - f) * math.Tan(DegreesToRadians(to.Latitude)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+	for i := 0; i < vincentyMaxIterations; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Sqrt(
+			math.Pow(cosU2*sinLambda, 2) + math.Pow(cosU1*sinU2-sinU1*cosU2*cosLambda, 2),
+		)
+		if sinSigma == 0 {
+			// Coincident points
+			return 0
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 {
+			// Equatorial lines have cosSqAlpha = 0
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+		C := f / 16 * cosSqAlpha * (4 + f*(4-3*cosSqAlpha))
+		previousLambda := lambda
+		lambda = L + (1-C)*f*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+		if math.Abs(lambda-previousLambda) < vincentyConvergenceThreshold {
+			uSq := cosSqAlpha * (a*a - b*b) / (b * b)
+			A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+			B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+			deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+				B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+			return b * A * (sigma - deltaSigma)
+		}
+	}
+
+	return HaversineDistance(from, to)
+}
+
+// Distance in meters between two points, this is the one to use by default
+func Distance(from Point, to Point) float64 {
+	return VincentyDistance(from, to)
+}
+
+// Initial bearing in degrees, clockwise from true north, in the [0, 360[ range
+func InitialBearing(from Point, to Point) float64 {
+	lat1 := DegreesToRadians(from.Latitude)
+	lat2 := DegreesToRadians(to.Latitude)
+	deltaLon := DegreesToRadians(to.Longitude - from.Longitude)
+
+	y := math.Sin(deltaLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(deltaLon)
+	return normalizeBearing(RadiansToDegrees(math.Atan2(y, x)))
+}
+
+// Point reached when travelling distanceMeters along the great circle starting
+// at from with the given initial bearing (degrees clockwise from true north)
+func DestinationPoint(from Point, bearingDegrees float64, distanceMeters float64) Point {
+	lat1 := DegreesToRadians(from.Latitude)
+	lon1 := DegreesToRadians(from.Longitude)
+	bearing := DegreesToRadians(bearingDegrees)
+	angularDistance := distanceMeters / EarthMeanRadiusMeters
+
+	sinLat1, cosLat1 := math.Sincos(lat1)
+	sinAngular, cosAngular := math.Sincos(angularDistance)
+	lat2 := math.Asin(sinLat1*cosAngular + cosLat1*sinAngular*math.Cos(bearing))
+	lon2 := lon1 + math.Atan2(
+		math.Sin(bearing)*sinAngular*cosLat1,
+		cosAngular-sinLat1*math.Sin(lat2),
+	)
+
+	return Point{
+		Latitude:  RadiansToDegrees(lat2),
+		Longitude: normalizeLongitude(RadiansToDegrees(lon2)),
+	}
+}
+
+// Radii of curvature of the WGS84 ellipsoid at a given latitude, in meters.
+// The meridional one applies to north/south displacements, the prime vertical one to east/west displacements.
+func radiiOfCurvature(latitude float64) (meridional float64, primeVertical float64) {
+	eccentricitySq := EarthFlattening * (2 - EarthFlattening)
+	sinLat := math.Sin(DegreesToRadians(latitude))
+	denominator := 1 - eccentricitySq*sinLat*sinLat
+	primeVertical = EarthEquatorialRadiusMeters / math.Sqrt(denominator)
+	meridional = EarthEquatorialRadiusMeters * (1 - eccentricitySq) / math.Pow(denominator, 1.5)
+	return meridional, primeVertical
+}
+
+// Projects point on the local East-North plane tangent to the ellipsoid at origin.
+// Accurate to the centimeter over the few kilometers a garden spans.
+func ToENU(origin Point, point Point) (east float64, north float64) {
+	meridional, primeVertical := radiiOfCurvature(origin.Latitude)
+	deltaLon := normalizeLongitude(point.Longitude - origin.Longitude)
+	east = DegreesToRadians(deltaLon) * primeVertical * math.Cos(DegreesToRadians(origin.Latitude))
+	north = DegreesToRadians(point.Latitude-origin.Latitude) * meridional
+	return east, north
+}
+
+// Inverse of ToENU
+func FromENU(origin Point, east float64, north float64) Point {
+	meridional, primeVertical := radiiOfCurvature(origin.Latitude)
+	return Point{
+		Latitude: origin.Latitude + RadiansToDegrees(north/meridional),
+		Longitude: normalizeLongitude(
+			origin.Longitude + RadiansToDegrees(east/(primeVertical*math.Cos(DegreesToRadians(origin.Latitude)))),
+		),
+	}
+}
+
+// Area in square meters enclosed by the polygon, vertices may be given in either winding order
+// and the polygon does not need to be closed. Computed on the local tangent plane of the first vertex.
+func PolygonArea(vertices []Point) float64 {
+	if len(vertices) < 3 {
+		return 0
+	}
+
+	origin := vertices[0]
+	doubleArea := 0.0
+	for i := range vertices {
+		x1, y1 := ToENU(origin, vertices[i])
+		x2, y2 := ToENU(origin, vertices[(i+1)%len(vertices)])
+		doubleArea += x1*y2 - x2*y1
+	}
+	return math.Abs(doubleArea) / 2
+}
@@ -0,0 +1,213 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func degreesMinutesSeconds(degrees float64, minutes float64, seconds float64) float64 {
+	return math.Copysign(math.Abs(degrees)+minutes/60+seconds/3600, degrees)
+}
+
+// Reference values from Vincenty's 1975 paper, the WGS84 ellipsoid axes, and the examples
+// published with the haversine and destination point formulas on a 6371km sphere
+func TestDistances(t *testing.T) {
+	flindersPeak := NewPoint(degreesMinutesSeconds(-37, 57, 3.72030), degreesMinutesSeconds(144, 25, 29.52440))
+	buninyong := NewPoint(degreesMinutesSeconds(-37, 39, 10.15610), degreesMinutesSeconds(143, 55, 35.38390))
+	landsEnd := NewPoint(degreesMinutesSeconds(50, 3, 59), degreesMinutesSeconds(-5, 42, 53))
+	johnOGroats := NewPoint(degreesMinutesSeconds(58, 38, 38), degreesMinutesSeconds(-3, 4, 12))
+	// The published references use a 6371km radius
+	sphereScale := EarthMeanRadiusMeters / 6371000
+
+	tests := []struct {
+		name      string
+		distance  func(Point, Point) float64
+		from      Point
+		to        Point
+		expected  float64
+		tolerance float64
+	}{
+		{"Vincenty Flinders Peak to Buninyong", VincentyDistance, flindersPeak, buninyong, 54972.271, 0.001},
+		{"Vincenty Buninyong to Flinders Peak", VincentyDistance, buninyong, flindersPeak, 54972.271, 0.001},
+		{"Vincenty one degree along the equator", VincentyDistance, NewPoint(0, 0), NewPoint(0, 1), 111319.491, 0.001},
+		{"Vincenty one degree along the meridian", VincentyDistance, NewPoint(0, 0), NewPoint(1, 0), 110574.389, 0.001},
+		{"Vincenty equator to pole", VincentyDistance, NewPoint(0, 0), NewPoint(90, 0), 10001965.729, 0.001},
+		{"Vincenty zero distance", VincentyDistance, flindersPeak, flindersPeak, 0, 0},
+		{"haversine Land's End to John o' Groats", HaversineDistance, landsEnd, johnOGroats, 968900 * sphereScale, 50},
+		{"haversine quarter of a great circle", HaversineDistance, NewPoint(0, 0), NewPoint(0, 90), EarthMeanRadiusMeters * math.Pi / 2, 1e-6},
+		{"haversine antipodes", HaversineDistance, NewPoint(45, 10), NewPoint(-45, -170), EarthMeanRadiusMeters * math.Pi, 1e-6},
+		{"haversine zero distance", HaversineDistance, landsEnd, landsEnd, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			distance := test.distance(test.from, test.to)
+			if math.Abs(distance-test.expected) > test.tolerance {
+				t.Errorf("Expected %.4fm, got %.4fm", test.expected, distance)
+			}
+		})
+	}
+}
+
+// Vincenty's formula does not converge for nearly antipodal points, the haversine distance is used instead
+func TestVincentyDistanceFallsBackForAntipodalPoints(t *testing.T) {
+	tests := []struct {
+		from Point
+		to   Point
+	}{
+		{NewPoint(0, 0), NewPoint(0.5, 179.7)},
+		{NewPoint(0, 0), NewPoint(-0.5, -179.7)},
+	}
+	for _, test := range tests {
+		distance := VincentyDistance(test.from, test.to)
+		haversineDistance := HaversineDistance(test.from, test.to)
+		if distance != haversineDistance {
+			t.Errorf("From %v to %v, expected the haversine distance %.3fm, got %.3fm", test.from, test.to, haversineDistance, distance)
+		}
+		if math.IsNaN(distance) || distance < 19900000 || distance > 20100000 {
+			t.Errorf("From %v to %v, expected about half the circumference, got %.3fm", test.from, test.to, distance)
+		}
+	}
+}
+
+func TestDestinationPoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      Point
+		bearing   float64
+		distance  float64
+		expected  Point
+		tolerance float64
+	}{
+		{
+			name:     "published example",
+			from:     NewPoint(degreesMinutesSeconds(53, 19, 14), degreesMinutesSeconds(-1, 43, 47)),
+			bearing:  degreesMinutesSeconds(96, 1, 18),
+			distance: 124800,
+			expected: NewPoint(degreesMinutesSeconds(53, 11, 18), degreesMinutesSeconds(0, 8, 0)),
+			// The example is rounded to the arcsecond
+			tolerance: 1.0 / 3600,
+		},
+		{"zero distance", NewPoint(47.5, 9.7), 42, 0, NewPoint(47.5, 9.7), 1e-12},
+		{"due north over the pole", NewPoint(89, 0), 0, EarthMeanRadiusMeters * DegreesToRadians(2), NewPoint(89, -180), 1e-9},
+		{"due east across the antimeridian", NewPoint(0, 179.5), 90, EarthMeanRadiusMeters * DegreesToRadians(1), NewPoint(0, -179.5), 1e-9},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destination := DestinationPoint(test.from, test.bearing, test.distance)
+			if math.Abs(destination.Latitude-test.expected.Latitude) > test.tolerance ||
+				math.Abs(normalizeLongitude(destination.Longitude-test.expected.Longitude)) > test.tolerance {
+				t.Errorf("Expected %v, got %v", test.expected, destination)
+			}
+		})
+	}
+}
+
+// Going to the destination point and measuring back must give the bearing and distance travelled
+func TestDestinationPointRoundTrip(t *testing.T) {
+	tests := []struct {
+		from     Point
+		bearing  float64
+		distance float64
+	}{
+		{NewPoint(47.5, 9.7), 0, 100},
+		{NewPoint(47.5, 9.7), 135, 2500},
+		{NewPoint(-33.9, 18.4), 270, 50000},
+		{NewPoint(64.1, -21.9), 45, 1000000},
+		{NewPoint(0, 179.9), 80, 30000},
+	}
+	for _, test := range tests {
+		destination := DestinationPoint(test.from, test.bearing, test.distance)
+		if distance := HaversineDistance(test.from, destination); math.Abs(distance-test.distance) > 1e-6*test.distance {
+			t.Errorf("From %v at %v°, expected %vm back, got %vm", test.from, test.bearing, test.distance, distance)
+		}
+		if bearing := InitialBearing(test.from, destination); math.Abs(math.Remainder(bearing-test.bearing, 360)) > 1e-6 {
+			t.Errorf("From %v at %v°, got a bearing of %v°", test.from, test.bearing, bearing)
+		}
+	}
+}
+
+// Lengths of a degree of latitude and longitude on the WGS84 ellipsoid, as published to the meter
+func TestToENU(t *testing.T) {
+	tests := []struct {
+		name          string
+		origin        Point
+		point         Point
+		expectedEast  float64
+		expectedNorth float64
+	}{
+		{"degree of longitude at the equator", NewPoint(0, 0), NewPoint(0, 1), 111320, 0},
+		{"degree of longitude at 30°", NewPoint(30, 0), NewPoint(30, 1), 96486, 0},
+		{"degree of longitude at 45°", NewPoint(45, 0), NewPoint(45, 1), 78847, 0},
+		{"degree of longitude at 60°", NewPoint(60, 0), NewPoint(60, 1), 55800, 0},
+		{"across the antimeridian", NewPoint(0, 179.5), NewPoint(0, -179.5), 111320, 0},
+		{"degree of latitude at the equator", NewPoint(0, 0), NewPoint(1, 0), 0, 110574},
+		{"degree of latitude at 45°", NewPoint(45, 0), NewPoint(46, 0), 0, 111132},
+		{"degree of latitude at 60°", NewPoint(60, 0), NewPoint(61, 0), 0, 111412},
+		{"same point", NewPoint(47.5, 9.7), NewPoint(47.5, 9.7), 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			east, north := ToENU(test.origin, test.point)
+			if math.Abs(east-test.expectedEast) > 1 || math.Abs(north-test.expectedNorth) > 1 {
+				t.Errorf("Expected (%.0f, %.0f), got (%.3f, %.3f)", test.expectedEast, test.expectedNorth, east, north)
+			}
+		})
+	}
+}
+
+func TestENURoundTrip(t *testing.T) {
+	origins := []Point{NewPoint(47.5, 9.7), NewPoint(-33.9, 18.4), NewPoint(0, 179.999), NewPoint(70, -180)}
+	offsets := [][2]float64{{0, 0}, {10, -20}, {-1500, 800}, {3000, 3000}}
+	for _, origin := range origins {
+		for _, offset := range offsets {
+			east, north := ToENU(origin, FromENU(origin, offset[0], offset[1]))
+			if math.Abs(east-offset[0]) > 1e-6 || math.Abs(north-offset[1]) > 1e-6 {
+				t.Errorf("From %v, expected (%v, %v) back, got (%v, %v)", origin, offset[0], offset[1], east, north)
+			}
+		}
+	}
+}
+
+func TestPolygonArea(t *testing.T) {
+	origin := NewPoint(47.5, 9.7)
+	tests := []struct {
+		name      string
+		vertices  []Point
+		expected  float64
+		tolerance float64
+	}{
+		{"too few vertices", []Point{origin, FromENU(origin, 100, 0)}, 0, 0},
+		{
+			name:      "right triangle",
+			vertices:  []Point{origin, FromENU(origin, 30, 0), FromENU(origin, 0, 40)},
+			expected:  600,
+			tolerance: 1e-6,
+		},
+		{
+			name:      "clockwise square",
+			vertices:  []Point{origin, FromENU(origin, 0, 100), FromENU(origin, 100, 100), FromENU(origin, 100, 0)},
+			expected:  10000,
+			tolerance: 1e-6,
+		},
+		{
+			name:      "closed square",
+			vertices:  []Point{origin, FromENU(origin, 100, 0), FromENU(origin, 100, 100), FromENU(origin, 0, 100), origin},
+			expected:  10000,
+			tolerance: 1e-6,
+		},
+		{
+			// Product of the lengths of a hundredth of a degree of longitude and latitude at the equator
+			name:      "hundredth of a degree cell at the equator",
+			vertices:  []Point{NewPoint(0, 0), NewPoint(0, 0.01), NewPoint(0.01, 0.01), NewPoint(0.01, 0)},
+			expected:  1113.20 * 1105.74,
+			tolerance: 10,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if area := PolygonArea(test.vertices); math.Abs(area-test.expected) > test.tolerance {
+				t.Errorf("Expected %.3fm², got %.3fm²", test.expected, area)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package robot
+
+import (
+	"math"
+
+	"paltech.robot/robot/geo"
+)
+
+type IdleReason string
+
+const (
+	IdleReasonNoDisplacement     IdleReason = "no_displacement"
+	IdleReasonNoWaypointProgress IdleReason = "no_waypoint_progress"
+	IdleReasonNoSpeed            IdleReason = "no_speed"
+)
+
+// Rules deciding when a robot that keeps sending updates is actually stuck.
+// Windows are expressed in robot time (status timestamps), a window of 0 disables the rule.
+type IdleRules struct {
+	MinDisplacementMeters         float64 `json:"min_displacement_meters"`
+	DisplacementWindowSeconds     int64   `json:"displacement_window_seconds"`
+	WaypointProgressWindowSeconds int64   `json:"waypoint_progress_window_seconds"`
+	MinSpeedMetersPerSecond       float64 `json:"min_speed_meters_per_second"`
+	SpeedWindowSeconds            int64   `json:"speed_window_seconds"`
+}
+
+var DefaultIdleRules = IdleRules{
+	MinDisplacementMeters:         5,
+	DisplacementWindowSeconds:     10 * 60,
+	WaypointProgressWindowSeconds: 30 * 60,
+	MinSpeedMetersPerSecond:       0.05,
+	SpeedWindowSeconds:            5 * 60,
+}
+
+// Index of the latest status sent before the last windowSeconds of robot time,
+// or -1 if the history is too short to cover the window
+func (robot *Robot) getStatusWindowStartIndex(windowSeconds int64) int {
+	if len(robot.StatusHistory) == 0 {
+		return -1
+	}
+	windowStart := robot.GetLatestStatus().Timestamp - windowSeconds
+	for i := len(robot.StatusHistory) - 1; i >= 0; i-- {
+		if robot.StatusHistory[i].Timestamp <= windowStart {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the statuses covering the last windowSeconds of robot time, starting with the
+// latest status sent before the window opened. Returns nil if the history is too short to cover the window.
+func (robot *Robot) getStatusWindow(windowSeconds int64) []*RobotStatus {
+	startIndex := robot.getStatusWindowStartIndex(windowSeconds)
+	if startIndex < 0 {
+		return nil
+	}
+	return robot.StatusHistory[startIndex:]
+}
+
+func hasNoDisplacement(window []*RobotStatus, minDisplacementMeters float64) bool {
+	latestPosition := window[len(window)-1].GetPosition()
+	maxDisplacement := 0.0
+	for _, status := range window {
+		maxDisplacement = math.Max(maxDisplacement, geo.Distance(status.GetPosition(), latestPosition))
+	}
+	return maxDisplacement < minDisplacementMeters
+}
+
+func hasNoWaypointProgress(window []*RobotStatus) bool {
+	return window[0].WaypointsReached == window[len(window)-1].WaypointsReached
+}
+
+func hasNoSpeed(window []*RobotStatus, minSpeedMetersPerSecond float64) bool {
+	for _, status := range window {
+		if status.GetForwardSpeed() >= minSpeedMetersPerSecond {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluates the idle rules against the status history, an empty result means the robot is making progress.
+// A robot that reached all its waypoints or that is charging is never considered idle.
+func (robot *Robot) DetectIdle(rules *IdleRules) []IdleReason {
+	reasons := make([]IdleReason, 0)
+	if len(robot.StatusHistory) == 0 {
+		return reasons
+	}
+	latestStatus := robot.GetLatestStatus()
+	if latestStatus.IsMissionFinished() || latestStatus.IsCharging() {
+		return reasons
+	}
+
+	if rules.DisplacementWindowSeconds > 0 {
+		window := robot.getStatusWindow(rules.DisplacementWindowSeconds)
+		if window != nil && hasNoDisplacement(window, rules.MinDisplacementMeters) {
+			reasons = append(reasons, IdleReasonNoDisplacement)
+		}
+	}
+	if rules.WaypointProgressWindowSeconds > 0 {
+		window := robot.getStatusWindow(rules.WaypointProgressWindowSeconds)
+		if window != nil && hasNoWaypointProgress(window) {
+			reasons = append(reasons, IdleReasonNoWaypointProgress)
+		}
+	}
+	if rules.SpeedWindowSeconds > 0 {
+		window := robot.getStatusWindow(rules.SpeedWindowSeconds)
+		if window != nil && hasNoSpeed(window, rules.MinSpeedMetersPerSecond) {
+			reasons = append(reasons, IdleReasonNoSpeed)
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,112 @@
+package robot
+
+import (
+	"math"
+
+	"paltech.robot/robot/geo"
+)
+
+type AnomalyType string
+
+const (
+	// Position moved further than the robot could physically drive in the elapsed time
+	AnomalyGPSJump AnomalyType = "gps_jump"
+	// Wheels turned noticeably more than the robot actually moved
+	AnomalyWheelSlip AnomalyType = "wheel_slip"
+	// Odometer keeps increasing while the position does not change at all
+	AnomalyStuck AnomalyType = "stuck"
+	// Speed reported by the robot disagrees with the speed measured from its positions
+	AnomalySpeedMismatch AnomalyType = "speed_mismatch"
+)
+
+const MaxPlausibleSpeedMetersPerSecond float64 = 5
+const GPSNoiseToleranceMeters float64 = 2
+const WheelSlipRatioThreshold float64 = 1.5
+const StuckOdometerMinDistanceMeters float64 = 5
+// Allows for the robot accelerating or braking between two statuses, on top of the GPS noise
+const SpeedMismatchToleranceMetersPerSecond float64 = 1
+// Beyond this heading change between two statuses the path was not straight,
+// so the odometer is expected to exceed the GPS distance
+const MaxStraightPathHeadingChangeRadians float64 = math.Pi / 8
+const MinSpeedForHeadingMetersPerSecond float64 = 0.05
+
+// Quantities derived from two consecutive statuses of a robot, and the anomalies
+// found when cross-checking the GPS positions against the reported odometry
+type Kinematics struct {
+	Timestamp        int64         `json:"timestamp"`
+	ElapsedSeconds   float64       `json:"elapsed_seconds"`
+	GPSDistance      float64       `json:"gps_distance"`
+	GPSSpeed         float64       `json:"gps_speed"`
+	GPSBearing       float64       `json:"gps_bearing"`
+	OdometerDistance float64       `json:"odom_distance"`
+	OdometerSpeed    float64       `json:"odom_speed"`
+	IsPathStraight   bool          `json:"is_path_straight"`
+	Anomalies        []AnomalyType `json:"anomalies"`
+}
+
+func ComputeKinematics(previous *RobotStatus, current *RobotStatus) *Kinematics {
+	kinematics := new(Kinematics)
+	kinematics.Timestamp = current.Timestamp
+	kinematics.ElapsedSeconds = float64(current.Timestamp - previous.Timestamp)
+	kinematics.GPSDistance = geo.Distance(previous.GetPosition(), current.GetPosition())
+	kinematics.GPSBearing = geo.InitialBearing(previous.GetPosition(), current.GetPosition())
+	kinematics.OdometerDistance = current.DistanceCovered - previous.DistanceCovered
+	// The speed reported in a status is the one the robot keeps until the next update
+	kinematics.OdometerSpeed = previous.GetForwardSpeed()
+	kinematics.IsPathStraight = isPathStraight(previous, current)
+	if kinematics.ElapsedSeconds > 0 {
+		kinematics.GPSSpeed = kinematics.GPSDistance / kinematics.ElapsedSeconds
+	}
+	kinematics.Anomalies = kinematics.detectAnomalies()
+	return kinematics
+}
+
+// Without a reported heading, only reliable when the robot was moving at both ends,
+// as a robot at rest may have turned on the spot
+func isPathStraight(previous *RobotStatus, current *RobotStatus) bool {
+	if !previous.HasHeading() || !current.HasHeading() {
+		if previous.GetForwardSpeed() < MinSpeedForHeadingMetersPerSecond || current.GetForwardSpeed() < MinSpeedForHeadingMetersPerSecond {
+			return false
+		}
+	}
+	headingChange := math.Remainder(current.GetHeadingDegrees()-previous.GetHeadingDegrees(), 360)
+	return math.Abs(geo.DegreesToRadians(headingChange)) <= MaxStraightPathHeadingChangeRadians
+}
+
+func (kinematics *Kinematics) detectAnomalies() []AnomalyType {
+	anomalies := make([]AnomalyType, 0)
+	maxPlausibleDistance := MaxPlausibleSpeedMetersPerSecond*math.Max(kinematics.ElapsedSeconds, 0) + GPSNoiseToleranceMeters
+
+	if kinematics.GPSDistance > maxPlausibleDistance {
+		anomalies = append(anomalies, AnomalyGPSJump)
+	} else if kinematics.GPSDistance <= GPSNoiseToleranceMeters {
+		if kinematics.OdometerDistance >= StuckOdometerMinDistanceMeters {
+			anomalies = append(anomalies, AnomalyStuck)
+		}
+	} else if kinematics.IsPathStraight &&
+		kinematics.OdometerDistance > kinematics.GPSDistance*WheelSlipRatioThreshold+GPSNoiseToleranceMeters {
+		anomalies = append(anomalies, AnomalyWheelSlip)
+	}
+	// The other anomalies already explain a speed mismatch
+	if len(anomalies) == 0 && kinematics.hasSpeedMismatch() {
+		anomalies = append(anomalies, AnomalySpeedMismatch)
+	}
+	return anomalies
+}
+
+// A curved path is longer than the straight line between its ends, so a GPS speed below the reported one
+// only counts on a straight path
+func (kinematics *Kinematics) hasSpeedMismatch() bool {
+	if kinematics.ElapsedSeconds <= 0 {
+		return false
+	}
+	tolerance := SpeedMismatchToleranceMetersPerSecond + GPSNoiseToleranceMeters/kinematics.ElapsedSeconds
+	if kinematics.GPSSpeed > kinematics.OdometerSpeed+tolerance {
+		return true
+	}
+	return kinematics.IsPathStraight && kinematics.GPSSpeed < kinematics.OdometerSpeed-tolerance
+}
+
+func (kinematics *Kinematics) HasAnomalies() bool {
+	return len(kinematics.Anomalies) > 0
+}
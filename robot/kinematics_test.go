@@ -0,0 +1,121 @@
+package robot
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"paltech.robot/robot/geo"
+)
+
+var kinematicsTestOrigin = geo.NewPoint(47.5, 9.7)
+
+// Status east and north of the test origin, driving at the given speed along the given heading
+func newKinematicsTestStatus(timestamp int64, east float64, north float64, headingDegrees float64, speed float64, distanceCovered float64) *RobotStatus {
+	status := &RobotStatus{Timestamp: timestamp, DistanceCovered: distanceCovered}
+	status.SetPosition(geo.FromENU(kinematicsTestOrigin, east, north))
+	status.SetDirectionAndForwardSpeed(HeadingToDirectionAngle(headingDegrees), speed)
+	status.SetHeadingDegrees(headingDegrees)
+	return status
+}
+
+func TestComputeKinematicsAnomalies(t *testing.T) {
+	tests := []struct {
+		name      string
+		previous  *RobotStatus
+		current   *RobotStatus
+		anomalies []AnomalyType
+	}{
+		{
+			name:      "consistent straight drive",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 2, 100),
+			current:   newKinematicsTestStatus(10, 20, 0, 90, 2, 120),
+			anomalies: []AnomalyType{},
+		},
+		{
+			name:      "position jumps further than the robot can drive",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 2, 100),
+			current:   newKinematicsTestStatus(10, 500, 0, 90, 2, 120),
+			anomalies: []AnomalyType{AnomalyGPSJump},
+		},
+		{
+			name:      "odometer increases while the position stays",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 0, 100),
+			current:   newKinematicsTestStatus(10, 0.5, 0, 90, 0, 110),
+			anomalies: []AnomalyType{AnomalyStuck},
+		},
+		{
+			name:      "wheels turn more than the robot moves on a straight path",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 1, 100),
+			current:   newKinematicsTestStatus(10, 10, 0, 90, 1, 130),
+			anomalies: []AnomalyType{AnomalyWheelSlip},
+		},
+		{
+			name:      "odometer exceeds the GPS distance on a curved path",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 1, 100),
+			current:   newKinematicsTestStatus(10, 10, 0, 180, 1, 130),
+			anomalies: []AnomalyType{},
+		},
+		{
+			name:      "GPS speed well above the reported speed",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 0.5, 100),
+			current:   newKinematicsTestStatus(10, 30, 0, 90, 0.5, 130),
+			anomalies: []AnomalyType{AnomalySpeedMismatch},
+		},
+		{
+			name:      "GPS speed well below the reported speed on a straight path",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 3, 100),
+			current:   newKinematicsTestStatus(10, 10, 0, 90, 3, 110),
+			anomalies: []AnomalyType{AnomalySpeedMismatch},
+		},
+		{
+			name:      "speed change within the tolerance",
+			previous:  newKinematicsTestStatus(0, 0, 0, 90, 1.5, 100),
+			current:   newKinematicsTestStatus(10, 20, 0, 90, 2.5, 120),
+			anomalies: []AnomalyType{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kinematics := ComputeKinematics(test.previous, test.current)
+			if !reflect.DeepEqual(kinematics.Anomalies, test.anomalies) {
+				t.Errorf("Expected anomalies %v, got %v (%+v)", test.anomalies, kinematics.Anomalies, *kinematics)
+			}
+			if kinematics.HasAnomalies() != (len(test.anomalies) > 0) {
+				t.Errorf("HasAnomalies disagrees with %v", kinematics.Anomalies)
+			}
+		})
+	}
+}
+
+func TestComputeKinematicsQuantities(t *testing.T) {
+	previous := newKinematicsTestStatus(100, 0, 0, 0, 2, 50)
+	current := newKinematicsTestStatus(110, 0, 20, 0, 1, 70)
+	kinematics := ComputeKinematics(previous, current)
+
+	if kinematics.Timestamp != 110 || kinematics.ElapsedSeconds != 10 {
+		t.Errorf("Expected timestamp 110 after 10s, got %d after %v", kinematics.Timestamp, kinematics.ElapsedSeconds)
+	}
+	if math.Abs(kinematics.GPSDistance-20) > 0.01 || math.Abs(kinematics.GPSSpeed-2) > 0.001 {
+		t.Errorf("Expected 20m at 2m/s, got %vm at %vm/s", kinematics.GPSDistance, kinematics.GPSSpeed)
+	}
+	if math.Abs(math.Remainder(kinematics.GPSBearing, 360)) > 0.01 {
+		t.Errorf("Expected a bearing due north, got %v", kinematics.GPSBearing)
+	}
+	// The speed of the previous status holds until the current one
+	if kinematics.OdometerDistance != 20 || math.Abs(kinematics.OdometerSpeed-2) > 1e-9 {
+		t.Errorf("Expected an odometer distance of 20m at 2m/s, got %vm at %vm/s", kinematics.OdometerDistance, kinematics.OdometerSpeed)
+	}
+	if !kinematics.IsPathStraight {
+		t.Error("Expected a straight path")
+	}
+}
+
+func TestComputeKinematicsWithoutElapsedTime(t *testing.T) {
+	previous := newKinematicsTestStatus(100, 0, 0, 90, 2, 50)
+	current := newKinematicsTestStatus(100, 0, 0, 90, 2, 50)
+	kinematics := ComputeKinematics(previous, current)
+	if kinematics.GPSSpeed != 0 || kinematics.HasAnomalies() {
+		t.Errorf("Expected no speed and no anomaly, got %v m/s and %v", kinematics.GPSSpeed, kinematics.Anomalies)
+	}
+}
@@ -0,0 +1,51 @@
+package robot
+
+import (
+	"errors"
+	"time"
+)
+
+// Decides when the server declares a robot timed out and how often it reports on it
+type LivenessPolicy struct {
+	UpdateTimeoutMilliseconds      int64 `json:"update_timeout_ms"`
+	GracePeriodMilliseconds        int64 `json:"grace_period_ms"`
+	PeriodicUpdatesIntervalSeconds int64 `json:"periodic_updates_interval_s"`
+}
+
+// Body of a registration request : the initial status, plus what the robot
+// tells the server about itself to negotiate its liveness policy
+type RobotRegistration struct {
+	RobotStatus
+	RobotClass                         string `json:"robot_class"`
+	ExpectedUpdateIntervalMilliseconds int64  `json:"expected_update_interval_ms"`
+}
+
+func (policy *LivenessPolicy) Validate() error {
+	if policy.UpdateTimeoutMilliseconds <= 0 {
+		return errors.New("Update timeout must be strictly positive")
+	}
+	if policy.GracePeriodMilliseconds < 0 {
+		return errors.New("Grace period must be positive")
+	}
+	if policy.PeriodicUpdatesIntervalSeconds <= 0 {
+		return errors.New("Periodic updates interval must be strictly positive")
+	}
+	return nil
+}
+
+// Time without update after which the robot is considered timed out
+func (policy *LivenessPolicy) GetTimeoutDuration() time.Duration {
+	return time.Duration(policy.UpdateTimeoutMilliseconds+policy.GracePeriodMilliseconds) * time.Millisecond
+}
+
+func (policy *LivenessPolicy) GetPeriodicUpdatesInterval() time.Duration {
+	return time.Duration(policy.PeriodicUpdatesIntervalSeconds) * time.Second
+}
+
+// Starts from the policy of the robot class, and trusts the robot about the interval it sends updates at
+func (policy LivenessPolicy) Negotiate(registration *RobotRegistration) LivenessPolicy {
+	if registration.ExpectedUpdateIntervalMilliseconds > 0 {
+		policy.UpdateTimeoutMilliseconds = registration.ExpectedUpdateIntervalMilliseconds
+	}
+	return policy
+}
@@ -0,0 +1,102 @@
+package maps
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sort"
+	"strconv"
+
+	"paltech.robot/robot"
+	"paltech.robot/robot/geo"
+)
+
+// From a single pass to passColors length passes and more
+var passColors = []color.RGBA{
+	{60, 180, 60, 255},
+	{230, 200, 0, 255},
+	{240, 120, 0, 255},
+	{200, 20, 20, 255},
+}
+
+func getPassColor(passes int) color.RGBA {
+	if passes > len(passColors) {
+		passes = len(passColors)
+	}
+	return passColors[passes-1]
+}
+
+func getPassLegend() []legendEntry {
+	legend := make([]legendEntry, 0, len(passColors))
+	for i, passColor := range passColors {
+		label := strconv.Itoa(i + 1)
+		if i == len(passColors)-1 {
+			label += "+"
+		}
+		legend = append(legend, legendEntry{label, passColor})
+	}
+	return legend
+}
+
+// Fitted on the worked ground whatever the configured zoom, cells would not be visible on the whole garden
+func getCoverageProjection(grid *robot.CoverageGrid, cells []robot.CoverageCell, mapOptions *robot.StaticMapOptions) projection {
+	points := make([]geo.Point, 0, 2*len(cells))
+	for _, cell := range cells {
+		corners := grid.GetCellCorners(cell)
+		points = append(points, corners[0], corners[2])
+	}
+	if len(points) == 0 {
+		return projection{
+			center:     geo.NewPoint(mapOptions.CenterLatitude, mapOptions.CenterLongitude),
+			zoom:       mapOptions.Zoom,
+			sizePixels: mapOptions.SizePixels,
+		}
+	}
+	return fitProjection(points, mapOptions.SizePixels)
+}
+
+// Cells coloured by their number of tool passes over the static map of the worked ground,
+// with a legend of the pass counts and a scale. Cells smaller than a pixel are drawn as one pixel.
+func RenderCoverageHeatmap(grid *robot.CoverageGrid, mapOptions *robot.StaticMapOptions) *image.RGBA {
+	cells := grid.GetCells()
+	proj := getCoverageProjection(grid, cells, mapOptions)
+	img := image.NewRGBA(image.Rect(0, 0, proj.sizePixels, proj.sizePixels))
+	draw.Draw(img, img.Bounds(), image.NewUniform(backgroundColor), image.Point{}, draw.Src)
+	if mapOptions.ApiKey != "" {
+		if err := drawBackground(img, proj, mapOptions); err != nil {
+			fmt.Println("Could not download the coverage heatmap background :", err)
+		}
+	}
+
+	// Most passed cells last, so that they stay visible where several cells share a pixel
+	sort.SliceStable(cells, func(i int, j int) bool { return cells[i].Passes < cells[j].Passes })
+	for _, cell := range cells {
+		corners := grid.GetCellCorners(cell)
+		westX, southY := proj.toImagePixels(corners[0])
+		eastX, northY := proj.toImagePixels(corners[2])
+		x, y := int(math.Floor(westX)), int(math.Floor(northY))
+		width, height := int(math.Ceil(eastX))-x, int(math.Ceil(southY))-y
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+		fillRectangle(img, x, y, width, height, getPassColor(cell.Passes))
+	}
+	drawLegend(img, getPassLegend())
+	drawScaleBar(img, proj)
+	return img
+}
+
+func RenderCoverageHeatmapPng(grid *robot.CoverageGrid, mapOptions *robot.StaticMapOptions) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, RenderCoverageHeatmap(grid, mapOptions)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,136 @@
+package maps
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// 3x5 pixel glyphs, enough for robot ids, pass counts and the scale
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'k': {"#..", "#.#", "##.", "#.#", "#.#"},
+	'm': {"...", "###", "###", "#.#", "#.#"},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	' ': {"...", "...", "...", "...", "..."},
+}
+
+const glyphWidth = 3
+const glyphHeight = 5
+
+// Width in pixels of the text drawn at this scale
+func getTextWidth(text string, scale int) int {
+	return len(text) * (glyphWidth + 1) * scale
+}
+
+// Characters without a glyph are left blank
+func drawText(img *image.RGBA, text string, x int, y int, scale int, textColor color.Color) {
+	for i, character := range text {
+		glyph := glyphs[character]
+		glyphX := x + i*(glyphWidth+1)*scale
+		for row, line := range glyph {
+			for column, pixel := range line {
+				if pixel == '#' {
+					fillRectangle(img, glyphX+column*scale, y+row*scale, scale, scale, textColor)
+				}
+			}
+		}
+	}
+}
+
+func fillRectangle(img *image.RGBA, x int, y int, width int, height int, fillColor color.Color) {
+	draw.Draw(img, image.Rect(x, y, x+width, y+height), image.NewUniform(fillColor), image.Point{}, draw.Over)
+}
+
+func fillDisc(img *image.RGBA, centerX float64, centerY float64, radius float64, fillColor color.Color) {
+	bounds := image.Rect(
+		int(math.Floor(centerX-radius)), int(math.Floor(centerY-radius)),
+		int(math.Ceil(centerX+radius))+1, int(math.Ceil(centerY+radius))+1,
+	).Intersect(img.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if math.Hypot(float64(x)-centerX, float64(y)-centerY) <= radius {
+				img.Set(x, y, fillColor)
+			}
+		}
+	}
+}
+
+// Liang–Barsky clipping of the segment to the rectangle, returns false when the segment is entirely outside
+func clipSegment(minX float64, minY float64, maxX float64, maxY float64,
+	fromX float64, fromY float64, toX float64, toY float64) (float64, float64, float64, float64, bool) {
+	deltaX, deltaY := toX-fromX, toY-fromY
+	enter, exit := 0.0, 1.0
+	// Each edge as p * t <= q, with p the motion towards the outside of the edge
+	edges := [4][2]float64{
+		{-deltaX, fromX - minX},
+		{deltaX, maxX - fromX},
+		{-deltaY, fromY - minY},
+		{deltaY, maxY - fromY},
+	}
+	for _, edge := range edges {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				// Parallel to the edge and outside of it
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			enter = math.Max(enter, t)
+		} else {
+			exit = math.Min(exit, t)
+		}
+		if enter > exit {
+			return 0, 0, 0, 0, false
+		}
+	}
+	return fromX + enter*deltaX, fromY + enter*deltaY, fromX + exit*deltaX, fromY + exit*deltaY, true
+}
+
+// Stamps discs along the segment, thick enough for paths and arrows.
+// The segment is clipped to the image first, so that far away points do not cost a step per pixel outside of it.
+func drawLine(img *image.RGBA, fromX float64, fromY float64, toX float64, toY float64, width float64, lineColor color.Color) {
+	margin := width/2 + 1
+	bounds := img.Bounds()
+	fromX, fromY, toX, toY, isVisible := clipSegment(
+		float64(bounds.Min.X)-margin, float64(bounds.Min.Y)-margin, float64(bounds.Max.X)+margin, float64(bounds.Max.Y)+margin,
+		fromX, fromY, toX, toY,
+	)
+	if !isVisible {
+		return
+	}
+	length := math.Hypot(toX-fromX, toY-fromY)
+	steps := int(math.Ceil(length))
+	for step := 0; step <= steps; step++ {
+		ratio := 0.0
+		if steps > 0 {
+			ratio = float64(step) / float64(steps)
+		}
+		fillDisc(img, fromX+(toX-fromX)*ratio, fromY+(toY-fromY)*ratio, width/2, lineColor)
+	}
+}
+
+// Heading in degrees clockwise from north, as reported by the robots
+func drawArrow(img *image.RGBA, x float64, y float64, headingDegrees float64, length float64, arrowColor color.Color) {
+	heading := headingDegrees * math.Pi / 180
+	tipX, tipY := x+length*math.Sin(heading), y-length*math.Cos(heading)
+	drawLine(img, x, y, tipX, tipY, 3, arrowColor)
+	headLength := length / 3
+	for _, side := range []float64{-1, 1} {
+		wingAngle := heading + math.Pi + side*math.Pi/6
+		drawLine(img, tipX, tipY, tipX+headLength*math.Sin(wingAngle), tipY-headLength*math.Cos(wingAngle), 3, arrowColor)
+	}
+}
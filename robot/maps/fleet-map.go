@@ -0,0 +1,245 @@
+package maps
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"strconv"
+
+	"paltech.robot/robot"
+	"paltech.robot/robot/geo"
+)
+
+// RGB values of the colour names of robot.PathColors
+var pathColors = map[string]color.RGBA{
+	"red":    {220, 30, 30, 255},
+	"blue":   {30, 80, 220, 255},
+	"green":  {20, 150, 40, 255},
+	"orange": {240, 140, 0, 255},
+	"purple": {140, 40, 180, 255},
+	"brown":  {130, 80, 30, 255},
+	"yellow": {230, 200, 0, 255},
+	"gray":   {120, 120, 120, 255},
+	"black":  {0, 0, 0, 255},
+}
+
+var backgroundColor = color.RGBA{235, 235, 225, 255}
+var legendBackgroundColor = color.NRGBA{255, 255, 255, 220}
+var outlineColor = color.RGBA{0, 0, 0, 255}
+var markerCenterColor = color.RGBA{255, 255, 255, 255}
+
+const pathWidthPixels = 3
+const markerRadiusPixels = 7
+const headingArrowLengthPixels = 24
+const textScale = 2
+const legendRowHeightPixels = 16
+const legendMarginPixels = 8
+
+// Meters per pixel at the equator at zoom 0
+const equatorMetersPerPixel = 156543.03392
+
+func GetRobotColor(robotId int) color.RGBA {
+	name := robot.GetPathColor(robotId)
+	if namedColor, isNamed := pathColors[name]; isNamed {
+		return namedColor
+	}
+	// Generated colours are 0xRRGGBB
+	rgb, _ := strconv.ParseUint(name[2:], 16, 32)
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}
+}
+
+func getProjection(robots []robot.Robot, mapOptions *robot.StaticMapOptions) projection {
+	configured := projection{
+		center:     geo.NewPoint(mapOptions.CenterLatitude, mapOptions.CenterLongitude),
+		zoom:       mapOptions.Zoom,
+		sizePixels: mapOptions.SizePixels,
+	}
+	if mapOptions.Zoom > 0 {
+		return configured
+	}
+	points := make([]geo.Point, 0)
+	for _, robot := range robots {
+		for _, status := range robot.StatusHistory {
+			points = append(points, status.GetPosition())
+		}
+	}
+	if len(points) == 0 {
+		return configured
+	}
+	return fitProjection(points, mapOptions.SizePixels)
+}
+
+// The static map of the projection, without overlays
+func drawBackground(img *image.RGBA, proj projection, mapOptions *robot.StaticMapOptions) error {
+	// At zoom 0 the maps API fits the map itself, which would not match the drawings
+	if proj.zoom == 0 {
+		return errors.New("The robots span the whole world, no background at zoom 0")
+	}
+	backgroundOptions := *mapOptions
+	backgroundOptions.Zoom = proj.zoom
+	backgroundOptions.CenterLatitude = proj.center.Latitude
+	backgroundOptions.CenterLongitude = proj.center.Longitude
+
+	response, err := robot.StaticMapsHttpClient.Get(backgroundOptions.GetBaseUrl() + "&key=" + mapOptions.ApiKey)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New("Static maps API answered " + response.Status)
+	}
+	background, _, err := image.Decode(response.Body)
+	if err != nil {
+		return err
+	}
+	if background.Bounds().Dx() != proj.sizePixels || background.Bounds().Dy() != proj.sizePixels {
+		return errors.New("Static maps API returned a " + strconv.Itoa(background.Bounds().Dx()) + "x" +
+			strconv.Itoa(background.Bounds().Dy()) + " map instead of " + strconv.Itoa(proj.sizePixels) + "px")
+	}
+	draw.Draw(img, img.Bounds(), background, background.Bounds().Min, draw.Src)
+	return nil
+}
+
+func drawPath(img *image.RGBA, proj projection, statuses []*robot.RobotStatus, pathColor color.Color) {
+	for i := 1; i < len(statuses); i++ {
+		fromX, fromY := proj.toImagePixels(statuses[i-1].GetPosition())
+		toX, toY := proj.toImagePixels(statuses[i].GetPosition())
+		drawLine(img, fromX, fromY, toX, toY, pathWidthPixels, pathColor)
+	}
+}
+
+// Robot colour ringed in black, with a white center, an arrow pointing to its heading and the robot id
+func drawPositionMarker(img *image.RGBA, proj projection, robotId int, status *robot.RobotStatus, markerColor color.Color) {
+	x, y := proj.toImagePixels(status.GetPosition())
+	drawArrow(img, x, y, status.GetHeadingDegrees(), headingArrowLengthPixels, outlineColor)
+	fillDisc(img, x, y, markerRadiusPixels+1, outlineColor)
+	fillDisc(img, x, y, markerRadiusPixels, markerColor)
+	fillDisc(img, x, y, markerRadiusPixels/3, markerCenterColor)
+
+	// On the side the heading arrow does not point to
+	label := strconv.Itoa(robotId)
+	labelX := int(x) + markerRadiusPixels + 3
+	if math.Sin(geo.DegreesToRadians(status.GetHeadingDegrees())) > 0 {
+		labelX = int(x) - markerRadiusPixels - 3 - getTextWidth(label, textScale)
+	}
+	labelY := int(y) - glyphHeight*textScale/2
+	fillRectangle(img, labelX-2, labelY-2, getTextWidth(label, textScale)+3, glyphHeight*textScale+4, legendBackgroundColor)
+	drawText(img, label, labelX, labelY, textScale, outlineColor)
+}
+
+type legendEntry struct {
+	label       string
+	swatchColor color.Color
+}
+
+// Colour swatch and label of every entry, in as many columns as needed
+func drawLegend(img *image.RGBA, entries []legendEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	swatchSize := glyphHeight * textScale
+	maxLabelWidth := 0
+	for _, entry := range entries {
+		if labelWidth := getTextWidth(entry.label, textScale); labelWidth > maxLabelWidth {
+			maxLabelWidth = labelWidth
+		}
+	}
+	columnWidth := swatchSize + legendMarginPixels/2 + maxLabelWidth + legendMarginPixels
+	rowsPerColumn := (img.Bounds().Dy() - 4*legendMarginPixels) / legendRowHeightPixels
+	if rowsPerColumn < 1 {
+		rowsPerColumn = 1
+	}
+	columns := (len(entries) + rowsPerColumn - 1) / rowsPerColumn
+	rows := rowsPerColumn
+	if len(entries) < rows {
+		rows = len(entries)
+	}
+	fillRectangle(img, legendMarginPixels, legendMarginPixels,
+		columns*columnWidth+legendMarginPixels, rows*legendRowHeightPixels+legendMarginPixels, legendBackgroundColor)
+
+	for i, entry := range entries {
+		x := 2*legendMarginPixels + (i/rowsPerColumn)*columnWidth
+		y := 2*legendMarginPixels + (i%rowsPerColumn)*legendRowHeightPixels
+		fillRectangle(img, x, y, swatchSize, swatchSize, entry.swatchColor)
+		drawText(img, entry.label, x+swatchSize+legendMarginPixels/2, y, textScale, outlineColor)
+	}
+}
+
+// Round length of about a fifth of the image, e.g. 200m or 1km
+func getScaleBarLength(metersPerPixel float64, sizePixels int) (float64, string) {
+	targetMeters := metersPerPixel * float64(sizePixels) / 5
+	magnitude := math.Pow(10, math.Floor(math.Log10(targetMeters)))
+	meters := magnitude
+	for _, multiple := range []float64{2, 5} {
+		if multiple*magnitude <= targetMeters {
+			meters = multiple * magnitude
+		}
+	}
+	if meters >= 1000 {
+		return meters, strconv.FormatFloat(meters/1000, 'f', -1, 64) + "km"
+	}
+	return meters, strconv.FormatFloat(meters, 'f', -1, 64) + "m"
+}
+
+func drawScaleBar(img *image.RGBA, proj projection) {
+	metersPerPixel := equatorMetersPerPixel * math.Cos(geo.DegreesToRadians(proj.center.Latitude)) / math.Exp2(float64(proj.zoom))
+	meters, label := getScaleBarLength(metersPerPixel, proj.sizePixels)
+	lengthPixels := int(meters / metersPerPixel)
+	textHeight := glyphHeight * textScale
+	height := textHeight + 3*legendMarginPixels
+	x := legendMarginPixels
+	y := img.Bounds().Dy() - legendMarginPixels - height
+	width := lengthPixels
+	if labelWidth := getTextWidth(label, textScale); labelWidth > width {
+		width = labelWidth
+	}
+	fillRectangle(img, x, y, width+2*legendMarginPixels, height, legendBackgroundColor)
+	drawText(img, label, x+legendMarginPixels, y+legendMarginPixels/2, textScale, outlineColor)
+	fillRectangle(img, x+legendMarginPixels, y+textHeight+legendMarginPixels, lengthPixels, 3, outlineColor)
+}
+
+// Paths of the robots in distinct colours over the static map of the area, with their latest position
+// and heading, a legend of the robot ids and a scale. The map is fitted on the robots unless a zoom is set.
+// Without an API key, or when the static map can not be downloaded, the background is left plain.
+func RenderFleetMap(robots []robot.Robot, mapOptions *robot.StaticMapOptions) *image.RGBA {
+	proj := getProjection(robots, mapOptions)
+	img := image.NewRGBA(image.Rect(0, 0, proj.sizePixels, proj.sizePixels))
+	draw.Draw(img, img.Bounds(), image.NewUniform(backgroundColor), image.Point{}, draw.Src)
+	if mapOptions.ApiKey != "" {
+		if err := drawBackground(img, proj, mapOptions); err != nil {
+			fmt.Println("Could not download the fleet map background :", err)
+		}
+	}
+
+	for _, robot := range robots {
+		drawPath(img, proj, robot.StatusHistory, GetRobotColor(robot.Id))
+	}
+	// Markers after all the paths so that no path hides them
+	for _, robot := range robots {
+		if len(robot.StatusHistory) > 0 {
+			drawPositionMarker(img, proj, robot.Id, robot.GetLatestStatus(), GetRobotColor(robot.Id))
+		}
+	}
+	legend := make([]legendEntry, 0, len(robots))
+	for _, robot := range robots {
+		legend = append(legend, legendEntry{strconv.Itoa(robot.Id), GetRobotColor(robot.Id)})
+	}
+	drawLegend(img, legend)
+	drawScaleBar(img, proj)
+	return img
+}
+
+func RenderFleetMapPng(robots []robot.Robot, mapOptions *robot.StaticMapOptions) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, RenderFleetMap(robots, mapOptions)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
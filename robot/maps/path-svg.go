@@ -0,0 +1,57 @@
+package maps
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strconv"
+
+	"paltech.robot/robot"
+)
+
+// Same colour as the paths drawn by the static maps API in the path images
+var svgPathColor = color.RGBA{255, 0, 0, 255}
+
+const svgPathWidthPixels = 2
+
+func formatSvgColor(svgColor color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", svgColor.R, svgColor.G, svgColor.B)
+}
+
+// Vector drawing of a robot path on the projection of the path images, with its latest position.
+// The static map background is raster and left out. The drawing scales to width x height pixels,
+// the map being square a single size sets both, and no size keeps the configured map size.
+func RenderPathSvg(statuses []*robot.RobotStatus, mapOptions *robot.StaticMapOptions, width int, height int) []byte {
+	proj := getProjection([]robot.Robot{{StatusHistory: statuses}}, mapOptions)
+	size := strconv.Itoa(proj.sizePixels)
+	switch {
+	case width == 0 && height == 0:
+		width, height = proj.sizePixels, proj.sizePixels
+	case width == 0:
+		width = height
+	case height == 0:
+		height = width
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %s %s">`,
+		width, height, size, size)
+	fmt.Fprintf(&buffer, `<rect width="%s" height="%s" fill="%s"/>`, size, size, formatSvgColor(backgroundColor))
+	if len(statuses) > 0 {
+		buffer.WriteString(`<polyline fill="none" stroke-linejoin="round" stroke-linecap="round" points="`)
+		for i, status := range statuses {
+			x, y := proj.toImagePixels(status.GetPosition())
+			if i > 0 {
+				buffer.WriteString(" ")
+			}
+			fmt.Fprintf(&buffer, "%.1f,%.1f", x, y)
+		}
+		fmt.Fprintf(&buffer, `" stroke="%s" stroke-width="%d"/>`, formatSvgColor(svgPathColor), svgPathWidthPixels)
+
+		x, y := proj.toImagePixels(statuses[len(statuses)-1].GetPosition())
+		fmt.Fprintf(&buffer, `<circle cx="%.1f" cy="%.1f" r="%d" fill="%s" stroke="%s"/>`,
+			x, y, markerRadiusPixels, formatSvgColor(svgPathColor), formatSvgColor(outlineColor))
+	}
+	buffer.WriteString("</svg>")
+	return buffer.Bytes()
+}
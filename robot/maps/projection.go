@@ -0,0 +1,67 @@
+package maps
+
+import (
+	"math"
+
+	"paltech.robot/robot/geo"
+)
+
+// Web Mercator as used by the static maps API, so that drawings line up with its backgrounds
+const tileSizePixels = 256
+const MaxZoom = 21
+
+// Part of the image left around the robots when the zoom is fitted on them
+const fitMarginRatio = 0.1
+
+// Pixel coordinates of the point in the whole world map at zoom 0
+func toWorldPixels(point geo.Point) (float64, float64) {
+	sinLatitude := math.Sin(geo.DegreesToRadians(point.Latitude))
+	// Clamped like the maps API to keep the poles finite
+	sinLatitude = math.Min(math.Max(sinLatitude, -0.9999), 0.9999)
+	x := tileSizePixels * (0.5 + point.Longitude/360)
+	y := tileSizePixels * (0.5 - math.Log((1+sinLatitude)/(1-sinLatitude))/(4*math.Pi))
+	return x, y
+}
+
+func fromWorldPixels(x float64, y float64) geo.Point {
+	longitude := (x/tileSizePixels - 0.5) * 360
+	latitude := geo.RadiansToDegrees(math.Atan(math.Sinh(math.Pi * (1 - 2*y/tileSizePixels))))
+	return geo.NewPoint(latitude, longitude)
+}
+
+type projection struct {
+	center     geo.Point
+	zoom       int
+	sizePixels int
+}
+
+func (proj *projection) toImagePixels(point geo.Point) (float64, float64) {
+	scale := math.Exp2(float64(proj.zoom))
+	x, y := toWorldPixels(point)
+	centerX, centerY := toWorldPixels(proj.center)
+	halfSize := float64(proj.sizePixels) / 2
+	return (x-centerX)*scale + halfSize, (y-centerY)*scale + halfSize
+}
+
+// Centers on the points with the highest zoom that shows all of them
+func fitProjection(points []geo.Point, sizePixels int) projection {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, point := range points {
+		x, y := toWorldPixels(point)
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+
+	extent := math.Max(maxX-minX, maxY-minY)
+	usableSize := float64(sizePixels) * (1 - 2*fitMarginRatio)
+	zoom := MaxZoom
+	for zoom > 0 && extent*math.Exp2(float64(zoom)) > usableSize {
+		zoom--
+	}
+	return projection{
+		center:     fromWorldPixels((minX+maxX)/2, (minY+maxY)/2),
+		zoom:       zoom,
+		sizePixels: sizePixels,
+	}
+}
@@ -0,0 +1,73 @@
+package maps
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Bilinear interpolation, averaging the source pixels covered by each destination pixel when shrinking
+// so that thin paths do not vanish from the thumbnails
+func ResizeImage(src image.Image, width int, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+	// Samples per destination pixel and direction, 1 when enlarging
+	samplesX, samplesY := int(math.Ceil(scaleX)), int(math.Ceil(scaleY))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var red, green, blue, alpha float64
+			for sampleY := 0; sampleY < samplesY; sampleY++ {
+				for sampleX := 0; sampleX < samplesX; sampleX++ {
+					srcX := (float64(x)+(float64(sampleX)+0.5)/float64(samplesX))*scaleX - 0.5
+					srcY := (float64(y)+(float64(sampleY)+0.5)/float64(samplesY))*scaleY - 0.5
+					r, g, b, a := sampleBilinear(src, srcX, srcY)
+					red, green, blue, alpha = red+r, green+g, blue+b, alpha+a
+				}
+			}
+			count := float64(samplesX * samplesY * 0x101)
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(math.Round(red / count)),
+				G: uint8(math.Round(green / count)),
+				B: uint8(math.Round(blue / count)),
+				A: uint8(math.Round(alpha / count)),
+			})
+		}
+	}
+	return dst
+}
+
+// Premultiplied 16 bit components at (x, y) relative to the bounds, clamped to the edges
+func sampleBilinear(src image.Image, x float64, y float64) (float64, float64, float64, float64) {
+	bounds := src.Bounds()
+	clamp := func(value int, limit int) int {
+		if value < 0 {
+			return 0
+		}
+		if value > limit-1 {
+			return limit - 1
+		}
+		return value
+	}
+	x0, y0 := math.Floor(x), math.Floor(y)
+	weightX, weightY := x-x0, y-y0
+	var components [4]float64
+	for _, corner := range [][3]float64{
+		{0, 0, (1 - weightX) * (1 - weightY)}, {1, 0, weightX * (1 - weightY)},
+		{0, 1, (1 - weightX) * weightY}, {1, 1, weightX * weightY},
+	} {
+		if corner[2] == 0 {
+			continue
+		}
+		pixelX := bounds.Min.X + clamp(int(x0+corner[0]), bounds.Dx())
+		pixelY := bounds.Min.Y + clamp(int(y0+corner[1]), bounds.Dy())
+		r, g, b, a := src.At(pixelX, pixelY).RGBA()
+		components[0] += float64(r) * corner[2]
+		components[1] += float64(g) * corner[2]
+		components[2] += float64(b) * corner[2]
+		components[3] += float64(a) * corner[2]
+	}
+	return components[0], components[1], components[2], components[3]
+}
@@ -0,0 +1,57 @@
+package robot
+
+func (robotStatus *RobotStatus) IsMissionFinished() bool {
+	return robotStatus.WaypointsReached >= robotStatus.WaypointsTotal
+}
+
+// Share of the waypoints reached, between 0 and 1
+func (robotStatus *RobotStatus) GetCompletion() float64 {
+	if robotStatus.WaypointsTotal == 0 {
+		return 1
+	}
+	return float64(robotStatus.WaypointsReached) / float64(robotStatus.WaypointsTotal)
+}
+
+// Share of the reached waypoints that were successful, between 0 and 1
+func (robotStatus *RobotStatus) GetWaypointSuccessRate() float64 {
+	if robotStatus.WaypointsReached == 0 {
+		return 0
+	}
+	return float64(robotStatus.WaypointsSuccessful) / float64(robotStatus.WaypointsReached)
+}
+
+// Robot time elapsed since the robot registered
+func (robot *Robot) GetMissionElapsedSeconds() int64 {
+	if len(robot.StatusHistory) == 0 {
+		return 0
+	}
+	return robot.GetLatestStatus().Timestamp - robot.StatusHistory[0].Timestamp
+}
+
+// Extrapolates the remaining robot time from the average time per waypoint so far.
+// Returns false until the robot reached a waypoint.
+func (robot *Robot) EstimateRemainingSeconds() (float64, bool) {
+	if len(robot.StatusHistory) == 0 {
+		return 0, false
+	}
+	firstStatus := robot.StatusHistory[0]
+	latestStatus := robot.GetLatestStatus()
+	reachedSinceStart := latestStatus.WaypointsReached - firstStatus.WaypointsReached
+	if reachedSinceStart <= 0 {
+		return 0, false
+	}
+	secondsPerWaypoint := float64(robot.GetMissionElapsedSeconds()) / float64(reachedSinceStart)
+	return secondsPerWaypoint * float64(latestStatus.WaypointsTotal-latestStatus.WaypointsReached), true
+}
+
+// Returns the statuses of the last windowSeconds of robot time, starting with the latest status
+// sent before the window opened, and the kinematics between them.
+// Unlike the idle rules window, the whole history is returned when it is shorter than the window.
+func (robot *Robot) GetHistorySince(windowSeconds int64) ([]*RobotStatus, []*Kinematics) {
+	startIndex := robot.getStatusWindowStartIndex(windowSeconds)
+	if startIndex < 0 {
+		startIndex = 0
+	}
+	// KinematicsHistory[i] was computed between StatusHistory[i] and StatusHistory[i+1]
+	return robot.StatusHistory[startIndex:], robot.KinematicsHistory[startIndex:]
+}
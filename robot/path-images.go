@@ -0,0 +1,260 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits on the path images kept on disk, a zero value disables the limit.
+// The latest image of every robot is always kept.
+type PathImageRetention struct {
+	MaxImagesPerRobot int
+	MaxAge            time.Duration
+	MaxTotalBytes     int64
+}
+
+type pathImage struct {
+	robotId             int
+	statusHistoryLength int
+	sizeBytes           int64
+	savedAt             time.Time
+}
+
+// Path images of the registered robots, oldest first for each robot
+type PathImageStore struct {
+	directory string
+	Retention PathImageRetention
+	images    map[int][]*pathImage
+	mutex     sync.Mutex
+}
+
+// Images and interrupted downloads of a previous run
+var leftoverFilePattern = regexp.MustCompile(`^path-\d+-\d+\.png(\.tmp\d*)?$`)
+var pathImageFilenamePattern = regexp.MustCompile(`^path-(\d{1,9})-(\d{1,9})\.png$`)
+
+// Creates the directory when missing. Files already in it are left alone, the store only removes the images it saved.
+func NewPathImageStore(directory string, retention PathImageRetention) (*PathImageStore, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("Could not create the path images directory : %w", err)
+	}
+	return &PathImageStore{
+		directory: directory,
+		Retention: retention,
+		images:    make(map[int][]*pathImage),
+	}, nil
+}
+
+// Robots do not outlive the server and their ids are reused after a restart, so the images left by a previous run
+// no longer match their robots. Only removes the files named like the images and interrupted downloads of a store.
+func RemoveLeftoverPathImages(directory string) error {
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && leftoverFilePattern.MatchString(entry.Name()) {
+			if err := os.Remove(path.Join(directory, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Inverse of GetPathImageFilename, only accepts the names it generates
+func ParsePathImageFilename(filename string) (int, int, error) {
+	matches := pathImageFilenamePattern.FindStringSubmatch(filename)
+	if matches == nil {
+		return 0, 0, errors.New("Invalid path image name " + strconv.Quote(filename))
+	}
+	robotId, _ := strconv.Atoi(matches[1])
+	statusHistoryLength, _ := strconv.Atoi(matches[2])
+	// Rejects leading zeros, so that every image has a single name
+	if GetPathImageFilename(robotId, statusHistoryLength) != filename {
+		return 0, 0, errors.New("Invalid path image name " + strconv.Quote(filename))
+	}
+	return robotId, statusHistoryLength, nil
+}
+
+func (store *PathImageStore) getImagePath(image *pathImage) string {
+	return path.Join(store.directory, GetPathImageFilename(image.robotId, image.statusHistoryLength))
+}
+
+// Fetches the static map of the robot path
+func (robot *Robot) DownloadPathImage(mapOptions *StaticMapOptions, writer io.Writer) error {
+	response, err := StaticMapsHttpClient.Get(mapOptions.GetUrl(robot.GetPath()))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New("Static maps API answered " + response.Status)
+	}
+	_, err = io.Copy(writer, response.Body)
+	return err
+}
+
+// Downloads the image of the robot path at its current length, then applies the retention limits.
+// The image is written under a temporary name first so that it is never read half written.
+func (store *PathImageStore) Save(robot *Robot, mapOptions *StaticMapOptions) error {
+	image := &pathImage{robotId: robot.Id, statusHistoryLength: len(robot.StatusHistory)}
+	file, err := os.CreateTemp(store.directory, GetPathImageFilename(image.robotId, image.statusHistoryLength)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = robot.DownloadPathImage(mapOptions, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), store.getImagePath(image))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	info, err := os.Stat(store.getImagePath(image))
+	if err != nil {
+		return err
+	}
+	image.sizeBytes = info.Size()
+	image.savedAt = time.Now()
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	// Concurrent updates of a robot may finish their downloads out of order
+	robotImages := store.images[robot.Id]
+	index := sort.Search(len(robotImages), func(i int) bool {
+		return robotImages[i].statusHistoryLength >= image.statusHistoryLength
+	})
+	if index < len(robotImages) && robotImages[index].statusHistoryLength == image.statusHistoryLength {
+		robotImages[index] = image
+	} else {
+		robotImages = append(robotImages, nil)
+		copy(robotImages[index+1:], robotImages[index:])
+		robotImages[index] = image
+	}
+	store.images[robot.Id] = robotImages
+	store.prune(image.savedAt)
+	fmt.Println("Saved path image at :", store.getImagePath(image))
+	return nil
+}
+
+func (store *PathImageStore) removeImage(image *pathImage) {
+	if err := os.Remove(store.getImagePath(image)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Println("Could not remove path image :", err)
+	}
+}
+
+// Removes every image of a deleted robot, including its latest one
+func (store *PathImageStore) RemoveRobot(robotId int) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, image := range store.images[robotId] {
+		store.removeImage(image)
+	}
+	delete(store.images, robotId)
+}
+
+// Called with the mutex held
+func (store *PathImageStore) prune(now time.Time) {
+	retention := store.Retention
+	removable := make([]*pathImage, 0)
+	totalBytes := int64(0)
+	for robotId, robotImages := range store.images {
+		kept := make([]*pathImage, 0, len(robotImages))
+		for i, image := range robotImages {
+			isLatest := i == len(robotImages)-1
+			isExtra := retention.MaxImagesPerRobot > 0 && len(robotImages)-i > retention.MaxImagesPerRobot
+			isExpired := retention.MaxAge > 0 && now.Sub(image.savedAt) > retention.MaxAge
+			if !isLatest && (isExtra || isExpired) {
+				store.removeImage(image)
+				continue
+			}
+			kept = append(kept, image)
+			totalBytes += image.sizeBytes
+			if !isLatest {
+				removable = append(removable, image)
+			}
+		}
+		store.images[robotId] = kept
+	}
+
+	if retention.MaxTotalBytes <= 0 || totalBytes <= retention.MaxTotalBytes {
+		return
+	}
+	sort.Slice(removable, func(i int, j int) bool { return removable[i].savedAt.Before(removable[j].savedAt) })
+	removed := make(map[*pathImage]bool)
+	for _, image := range removable {
+		if totalBytes <= retention.MaxTotalBytes {
+			break
+		}
+		store.removeImage(image)
+		removed[image] = true
+		totalBytes -= image.sizeBytes
+	}
+	for robotId, robotImages := range store.images {
+		kept := make([]*pathImage, 0, len(robotImages))
+		for _, image := range robotImages {
+			if !removed[image] {
+				kept = append(kept, image)
+			}
+		}
+		store.images[robotId] = kept
+	}
+}
+
+// Saved path image, exported for the HTTP caching headers
+type PathImageInfo struct {
+	Path                string
+	RobotId             int
+	StatusHistoryLength int
+	SizeBytes           int64
+	SavedAt             time.Time
+}
+
+func (store *PathImageStore) getInfo(image *pathImage) PathImageInfo {
+	return PathImageInfo{
+		Path:                store.getImagePath(image),
+		RobotId:             image.robotId,
+		StatusHistoryLength: image.statusHistoryLength,
+		SizeBytes:           image.sizeBytes,
+		SavedAt:             image.savedAt,
+	}
+}
+
+// Returns false until an image of the robot path was saved
+func (store *PathImageStore) GetLatest(robotId int) (PathImageInfo, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	robotImages := store.images[robotId]
+	if len(robotImages) == 0 {
+		return PathImageInfo{}, false
+	}
+	return store.getInfo(robotImages[len(robotImages)-1]), true
+}
+
+// Returns false when the image was never saved or was already removed
+func (store *PathImageStore) Get(robotId int, statusHistoryLength int) (PathImageInfo, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, image := range store.images[robotId] {
+		if image.statusHistoryLength == statusHistoryLength {
+			return store.getInfo(image), true
+		}
+	}
+	return PathImageInfo{}, false
+}
@@ -2,17 +2,36 @@ package robot
 
 import (
 	"math"
+
+	"paltech.robot/robot/geo"
 )
 
+// OdometerSpeed holds the speed north and east in m/s, then the yaw rate in rad/s, positive counterclockwise.
+// Heading is in degrees clockwise from true north, and is omitted by robots that do not report it.
+// Telemetry is only sent by robots from status version 2.
+// Acknowledgements answer the commands delivered in the response to the previous update.
 type RobotStatus struct {
-	Timestamp           int64      `json:"timestamp"`
-	Latitude            float64    `json:"lat"`
-	Longitude           float64    `json:"lon"`
-	OdometerSpeed       [3]float64 `json:"odom_speed"`
-	DistanceCovered     float64    `json:"distance_covered"`
-	WaypointsReached    int        `json:"waypoints_reached"`
-	WaypointsSuccessful int        `json:"waypoints_successful"`
-	WaypointsTotal      int        `json:"waypoints_total"`
+	Version             int                      `json:"version,omitempty"`
+	Timestamp           int64                    `json:"timestamp"`
+	Latitude            float64                  `json:"lat"`
+	Longitude           float64                  `json:"lon"`
+	OdometerSpeed       [3]float64               `json:"odom_speed"`
+	Heading             *float64                 `json:"heading,omitempty"`
+	DistanceCovered     float64                  `json:"distance_covered"`
+	WaypointsReached    int                      `json:"waypoints_reached"`
+	WaypointsSuccessful int                      `json:"waypoints_successful"`
+	WaypointsTotal      int                      `json:"waypoints_total"`
+	Telemetry           *Telemetry               `json:"telemetry,omitempty"`
+	Acknowledgements    []CommandAcknowledgement `json:"acknowledgements,omitempty"`
+}
+
+func (robotStatus *RobotStatus) GetPosition() geo.Point {
+	return geo.NewPoint(robotStatus.Latitude, robotStatus.Longitude)
+}
+
+func (robotStatus *RobotStatus) SetPosition(position geo.Point) {
+	robotStatus.Latitude = position.Latitude
+	robotStatus.Longitude = position.Longitude
 }
 
 func (robotStatus *RobotStatus) GetSpeedNorth() float64 {
@@ -23,6 +42,58 @@ func (robotStatus *RobotStatus) GetSpeedEast() float64 {
 	return robotStatus.OdometerSpeed[1]
 }
 
+func (robotStatus *RobotStatus) GetYawRate() float64 {
+	return robotStatus.OdometerSpeed[2]
+}
+
+func (robotStatus *RobotStatus) SetYawRate(yawRateRadiansPerSecond float64) {
+	robotStatus.OdometerSpeed[2] = yawRateRadiansPerSecond
+}
+
+// Turn rate in degrees per second, positive clockwise like the heading
+func (robotStatus *RobotStatus) GetTurnRateDegrees() float64 {
+	return -geo.RadiansToDegrees(robotStatus.GetYawRate())
+}
+
+func (robotStatus *RobotStatus) HasHeading() bool {
+	return robotStatus.Heading != nil
+}
+
+// Falls back to the direction of the speed for robots not reporting their heading
+func (robotStatus *RobotStatus) GetHeadingDegrees() float64 {
+	if robotStatus.HasHeading() {
+		return *robotStatus.Heading
+	}
+	return DirectionAngleToHeading(robotStatus.GetDirectionAngleNorth())
+}
+
+func (robotStatus *RobotStatus) SetHeadingDegrees(heading float64) {
+	// A new pointer each time, statuses are copied by value and must not share their heading
+	normalizedHeading := math.Mod(math.Mod(heading, 360) + 360, 360)
+	robotStatus.Heading = &normalizedHeading
+}
+
+// Converts a direction angle (radians counterclockwise from east, as used by
+// SetDirectionAndForwardSpeed) to a heading (degrees clockwise from north)
+func DirectionAngleToHeading(angleRadians float64) float64 {
+	heading := 90 - geo.RadiansToDegrees(angleRadians)
+	return math.Mod(math.Mod(heading, 360) + 360, 360)
+}
+
+func HeadingToDirectionAngle(headingDegrees float64) float64 {
+	return math.Remainder(geo.DegreesToRadians(90 - headingDegrees), 2 * math.Pi)
+}
+
+func GetCompassPoint(headingDegrees float64) string {
+	compassPoints := []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+	index := int(math.Round(math.Mod(math.Mod(headingDegrees, 360) + 360, 360) / 45)) % len(compassPoints)
+	return compassPoints[index]
+}
+
+func (robotStatus *RobotStatus) GetForwardSpeed() float64 {
+	return math.Hypot(robotStatus.GetSpeedNorth(), robotStatus.GetSpeedEast())
+}
+
 func (robotStatus *RobotStatus) GetDirectionAngleNorth() float64 {
 	return math.Atan2(robotStatus.GetSpeedNorth(), robotStatus.GetSpeedEast())
 }
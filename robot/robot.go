@@ -2,21 +2,24 @@ package robot
 
 import (
 	"fmt"
-	"io"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
+	"time"
 )
 
 
 type Robot struct {
-	Id            int
-	StatusHistory []*RobotStatus
+	Id                int
+	StatusHistory     []*RobotStatus
+	KinematicsHistory []*Kinematics
 }
 
 // Function is exported only if it starts with uppercase
 func (robot *Robot) AppendStatus(robotStatus *RobotStatus) { 
+	if len(robot.StatusHistory) > 0 {
+		kinematics := ComputeKinematics(robot.GetLatestStatus(), robotStatus)
+		robot.KinematicsHistory = append(robot.KinematicsHistory, kinematics)
+	}
 	robot.StatusHistory = append(robot.StatusHistory, robotStatus)
 }
 
@@ -24,6 +27,14 @@ func (robot *Robot) GetLatestStatus() *RobotStatus {
 	return robot.StatusHistory[len(robot.StatusHistory) - 1]
 }
 
+// Returns nil until the robot has sent at least one update after registering
+func (robot *Robot) GetLatestKinematics() *Kinematics {
+	if len(robot.KinematicsHistory) == 0 {
+		return nil
+	}
+	return robot.KinematicsHistory[len(robot.KinematicsHistory) - 1]
+}
+
 func (robot *Robot) ToString() string {
 	str := "Robot Object\n"
 	str += "id : " + strconv.Itoa(robot.Id) + "\n"
@@ -47,28 +58,27 @@ func (robot *Robot) GetPath() string {
 	return pathString
 }
 
-func (robot *Robot) GenerateAndSavePathImage() {
-	statusHistoryLength := len(robot.StatusHistory)
-	url := "https://maps.googleapis.com/maps/api/staticmap?size=1000x1000&zoom=15&center=48.218885,11.607754&path=color:0xff0000ff|weight:1|"
-	url += robot.GetPath()
-	url += "&sensor=false&key=MAPSKEY"
+type StaticMapOptions struct {
+	ApiKey          string
+	CenterLatitude  float64
+	CenterLongitude float64
+	// A zoom of 0 lets the maps API center and fit the map on the path
+	Zoom       int
+	SizePixels int
+}
+
+// Maps are fetched while handling robot updates, API requests and chats, which a hung maps API must not block
+const StaticMapsRequestTimeout = 15 * time.Second
 
-	filepath := "pathImages/path-" + strconv.Itoa(robot.Id) + "-" + strconv.Itoa(statusHistoryLength) + ".png"
-    file, err := os.Create(filepath)
-    if err != nil {
-        log.Fatal(err)
-    }
-    defer file.Close()
+var StaticMapsHttpClient = &http.Client{Timeout: StaticMapsRequestTimeout}
 
-	response, e := http.Get(url)
-    if e != nil {
-        log.Fatal(e)
-    }
-    defer response.Body.Close()
+func (options *StaticMapOptions) GetUrl(path string) string {
+	url := options.GetBaseUrl()
+	url += "&path=color:0xff0000ff|weight:1|" + path
+	url += "&sensor=false&key=" + options.ApiKey
+	return url
+}
 
-    _, err = io.Copy(file, response.Body)
-    if err != nil {
-        log.Fatal(err)
-    }
-    fmt.Println("Downloaded path image at : ", filepath)
-}
\ No newline at end of file
+func GetPathImageFilename(robotId int, statusHistoryLength int) string {
+	return "path-" + strconv.Itoa(robotId) + "-" + strconv.Itoa(statusHistoryLength) + ".png"
+}
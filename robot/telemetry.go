@@ -0,0 +1,95 @@
+package robot
+
+import (
+	"strconv"
+)
+
+// Statuses without a version are version 1 : position, speed, distance and waypoints only.
+// Version 2 adds the optional telemetry.
+const CurrentStatusVersion = 2
+
+type GNSSFixQuality string
+
+const (
+	GNSSFixNone     GNSSFixQuality = "none"
+	GNSSFix2D       GNSSFixQuality = "2d"
+	GNSSFix3D       GNSSFixQuality = "3d"
+	GNSSFixDGPS     GNSSFixQuality = "dgps"
+	GNSSFixRTKFloat GNSSFixQuality = "rtk_float"
+	GNSSFixRTKFixed GNSSFixQuality = "rtk_fixed"
+)
+
+type RobotTask string
+
+const (
+	TaskIdle            RobotTask = "idle"
+	TaskNavigating      RobotTask = "navigating"
+	TaskWeeding         RobotTask = "weeding"
+	TaskReturningToBase RobotTask = "returning_to_base"
+	TaskCharging        RobotTask = "charging"
+)
+
+// Health and task state reported by robots from status version 2
+type Telemetry struct {
+	BatteryPercent     float64        `json:"battery_percent"`
+	IsCharging         bool           `json:"is_charging"`
+	MotorTemperaturesC []float64      `json:"motor_temperatures_c,omitempty"`
+	ErrorCodes         []string       `json:"error_codes,omitempty"`
+	GNSSFix            GNSSFixQuality `json:"gnss_fix,omitempty"`
+	SatelliteCount     int            `json:"satellite_count"`
+	CurrentTask        RobotTask      `json:"current_task,omitempty"`
+}
+
+func (robotStatus *RobotStatus) GetVersion() int {
+	if robotStatus.Version == 0 {
+		return 1
+	}
+	return robotStatus.Version
+}
+
+func (robotStatus *RobotStatus) HasTelemetry() bool {
+	return robotStatus.Telemetry != nil
+}
+
+func (robotStatus *RobotStatus) IsCharging() bool {
+	return robotStatus.HasTelemetry() && robotStatus.Telemetry.IsCharging
+}
+
+func (telemetry *Telemetry) GetMaxMotorTemperature() float64 {
+	maxTemperature := 0.0
+	for i, temperature := range telemetry.MotorTemperaturesC {
+		if i == 0 || temperature > maxTemperature {
+			maxTemperature = temperature
+		}
+	}
+	return maxTemperature
+}
+
+func (telemetry *Telemetry) ToString() string {
+	str := " - Battery : " + strconv.FormatFloat(telemetry.BatteryPercent, 'f', 0, 64) + "%"
+	if telemetry.IsCharging {
+		str += " (charging)"
+	}
+	str += "\n"
+	if telemetry.CurrentTask != "" {
+		str += " - Task : " + string(telemetry.CurrentTask) + "\n"
+	}
+	if telemetry.GNSSFix != "" {
+		str += " - GNSS : " + string(telemetry.GNSSFix) + " fix, " + strconv.Itoa(telemetry.SatelliteCount) + " satellites\n"
+	}
+	if len(telemetry.MotorTemperaturesC) > 0 {
+		str += " - Motors :"
+		for _, temperature := range telemetry.MotorTemperaturesC {
+			str += " " + strconv.FormatFloat(temperature, 'f', 0, 64) + "°C"
+		}
+		str += "\n"
+	}
+	if len(telemetry.ErrorCodes) > 0 {
+		str += " - Errors :"
+		for _, errorCode := range telemetry.ErrorCodes {
+			str += " " + errorCode
+		}
+		str += "\n"
+	}
+	return str
+}
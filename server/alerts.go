@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"paltech.config/config"
+	. "paltech.robot/robot"
+)
+
+// How often snoozed alerts are checked for reminders and unacknowledged alerts for escalation
+const AlertTimersCheckInterval = 10 * time.Second
+const DefaultAlertSnoozeMinutes = 30
+
+var alertManager *AlertManager
+
+func getAlertRulesFromConfig(rulesConfig []config.AlertRuleConfig) []AlertRule {
+	if len(rulesConfig) == 0 {
+		return DefaultAlertRules
+	}
+	rules := make([]AlertRule, 0, len(rulesConfig))
+	for _, ruleConfig := range rulesConfig {
+		rules = append(rules, AlertRule{
+			Name:            ruleConfig.Name,
+			Condition:       ruleConfig.Condition,
+			Severity:        AlertSeverity(ruleConfig.Severity),
+			CooldownSeconds: ruleConfig.CooldownSeconds,
+		})
+	}
+	return rules
+}
+
+func getAlertRules(c echo.Context) error {
+	return c.JSON(http.StatusOK, alertManager.GetRules())
+}
+
+func setAlertRules(c echo.Context) error {
+	var parsedRules []AlertRule
+	if err := c.Bind(&parsedRules); err != nil {
+		return err
+	}
+	if err := alertManager.SetRules(parsedRules); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, alertManager.GetRules())
+}
+
+func putAlertRule(c echo.Context) error {
+	parsedRule := new(AlertRule)
+	if err := c.Bind(parsedRule); err != nil {
+		return err
+	}
+	parsedRule.Name = c.Param("name")
+	if err := alertManager.PutRule(*parsedRule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, alertManager.GetRules())
+}
+
+func deleteAlertRule(c echo.Context) error {
+	if err := alertManager.DeleteRule(c.Param("name")); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Only active alerts unless called with ?all=true
+func getAlerts(c echo.Context) error {
+	onlyActive := c.QueryParam("all") != "true"
+	return c.JSON(http.StatusOK, alertManager.GetAlerts(onlyActive))
+}
+
+func acknowledgeAlert(c echo.Context) error {
+	id, httpErr := parseId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	acknowledgedBy := c.QueryParam("by")
+	if acknowledgedBy == "" {
+		acknowledgedBy = "api"
+	}
+
+	alert, err := alertManager.Acknowledge(id, c.Param("rule"), acknowledgedBy)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return c.JSON(http.StatusOK, alert)
+}
+
+// Snoozes for ?minutes=, 30 minutes by default
+func snoozeAlert(c echo.Context) error {
+	id, httpErr := parseId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	snoozeMinutes := DefaultAlertSnoozeMinutes
+	if minutesParam := c.QueryParam("minutes"); minutesParam != "" {
+		parsedMinutes, err := strconv.Atoi(minutesParam)
+		if err != nil || parsedMinutes <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Snooze minutes must be a positive integer")
+		}
+		snoozeMinutes = parsedMinutes
+	}
+	snoozedBy := c.QueryParam("by")
+	if snoozedBy == "" {
+		snoozedBy = "api"
+	}
+
+	alert, err := alertManager.Snooze(id, c.Param("rule"), time.Duration(snoozeMinutes)*time.Minute, snoozedBy)
+	if errors.Is(err, ErrNoActiveAlert) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		// e.g. the alert is already acknowledged
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return c.JSON(http.StatusOK, alert)
+}
+
+func getRobotAlerts(c echo.Context) error {
+	id, httpErr := parseId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	robotAlerts := make([]Alert, 0)
+	for _, alert := range alertManager.GetAlerts(c.QueryParam("all") != "true") {
+		if alert.RobotId == id {
+			robotAlerts = append(robotAlerts, alert)
+		}
+	}
+	return c.JSON(http.StatusOK, robotAlerts)
+}
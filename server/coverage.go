@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"paltech.config/config"
+	. "paltech.robot/robot"
+	"paltech.robot/robot/maps"
+)
+
+var coverageCache *CoverageCache
+
+func getCoverageSettingsFromConfig(coverageConfig *config.CoverageConfig) (*CoverageSettings, error) {
+	gardenArea := DefaultGardenArea
+	if coverageConfig.GardenAreaFile != "" {
+		var err error
+		gardenArea, err = LoadGardenArea(coverageConfig.GardenAreaFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &CoverageSettings{
+		ToolWidthMeters: coverageConfig.ToolWidthMeters,
+		CellSizeMeters:  coverageConfig.CellSizeMeters,
+		GardenArea:      gardenArea,
+	}, nil
+}
+
+// Copy of the robot, its coverage is computed without holding the mutex
+func getCoverageRobot(c echo.Context) (Robot, error) {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return Robot{}, httpErr
+	}
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	if robots[id] == nil {
+		return Robot{}, echo.NewHTTPError(http.StatusNotFound, "Robot "+strconv.Itoa(id)+" was deleted")
+	}
+	return *robots[id], nil
+}
+
+func getRobotCoverage(c echo.Context) error {
+	robot, httpErr := getCoverageRobot(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	return c.JSON(http.StatusOK, coverageCache.GetCoverage(&robot))
+}
+
+func getRobotCoverageHeatmap(c echo.Context) error {
+	robot, httpErr := getCoverageRobot(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	image, err := maps.RenderCoverageHeatmapPng(coverageCache.GetGrid(&robot), staticMapOptions)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.Blob(http.StatusOK, "image/png", image)
+}
+
+// Pass counts of every covered cell, for GIS tools
+func getRobotCoverageGrid(c echo.Context) error {
+	robot, httpErr := getCoverageRobot(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	grid := coverageCache.GetGrid(&robot).ToGeoJSON()
+	c.Response().Header().Set(echo.HeaderContentType, "application/geo+json")
+	return c.JSON(http.StatusOK, grid)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	. "paltech.robot/robot"
+	"paltech.robot/robot/maps"
+)
+
+// Robots listed in the comma separated ids parameter, or the whole fleet without it
+func getFleetMapRobots(idsParam string) ([]Robot, error) {
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	if idsParam == "" {
+		fleet := make([]Robot, 0, len(robots))
+		for _, robot := range robots {
+			if robot != nil {
+				fleet = append(fleet, *robot)
+			}
+		}
+		return fleet, nil
+	}
+
+	selected := make([]Robot, 0)
+	for _, idText := range strings.Split(idsParam, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(idText))
+		if err != nil || id < 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid robot id : "+idText)
+		}
+		if id >= len(robots) || robots[id] == nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Unknown robot id : "+idText)
+		}
+		selected = append(selected, *robots[id])
+	}
+	return selected, nil
+}
+
+func getFleetMap(c echo.Context) error {
+	fleet, err := getFleetMapRobots(c.QueryParam("ids"))
+	if err != nil {
+		return err
+	}
+	if len(fleet) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "No robot registered")
+	}
+
+	image, err := maps.RenderFleetMapPng(fleet, staticMapOptions)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.Blob(http.StatusOK, "image/png", image)
+}
@@ -5,6 +5,7 @@ go 1.20
 require (
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/labstack/echo/v4 v4.10.2
+	paltech.config/config v0.0.0-00010101000000-000000000000
 	paltech.robot/robot v0.0.0-00010101000000-000000000000
 	paltech.telegram_bot/telegram_bot v0.0.0-00010101000000-000000000000
 )
@@ -19,8 +20,11 @@ require (
 	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace paltech.telegram_bot/telegram_bot => ../telegram_bot
 
+replace paltech.config/config => ../config
+
 replace paltech.robot/robot => ../robot
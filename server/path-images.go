@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"paltech.config/config"
+	. "paltech.robot/robot"
+	"paltech.robot/robot/maps"
+)
+
+const MaxPathImageSizePixels = 2048
+
+// Latest images change with every update and are revalidated, saved versions do not change
+const LatestPathImageCacheControl = "no-cache"
+const PathImageVersionCacheControl = "public, max-age=86400"
+
+var pathImages *PathImageStore
+
+var pathImageContentTypes = map[string]string{
+	"png": "image/png",
+	"svg": "image/svg+xml",
+}
+
+// Rendering of a path image asked by the client, the stored image when the sizes are 0 and the format is png
+type pathImageRendering struct {
+	width  int
+	height int
+	format string
+}
+
+func (rendering *pathImageRendering) isOriginal() bool {
+	return rendering.width == 0 && rendering.height == 0 && rendering.format == "png"
+}
+
+func getPathImageRetentionFromConfig(serverConfig *config.ServerConfig) PathImageRetention {
+	return PathImageRetention{
+		MaxImagesPerRobot: serverConfig.PathImagesPerRobot,
+		MaxAge:            time.Duration(serverConfig.PathImagesMaxAgeMinutes) * time.Minute,
+		MaxTotalBytes:     int64(serverConfig.PathImagesMaxTotalMegabytes) * 1024 * 1024,
+	}
+}
+
+func parsePathImageSize(c echo.Context, name string) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 || size > MaxPathImageSizePixels {
+		return 0, echo.NewHTTPError(http.StatusBadRequest,
+			name+" must be a number of pixels between 1 and "+strconv.Itoa(MaxPathImageSizePixels))
+	}
+	return size, nil
+}
+
+// Picks the supported format with the highest quality in the Accept header, png for wildcards
+func negotiatePathImageFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "png", true
+	}
+	bestFormat, bestQuality := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		parameters := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parameters[0]))
+		quality := 1.0
+		for _, parameter := range parameters[1:] {
+			if value, isQuality := strings.CutPrefix(strings.TrimSpace(parameter), "q="); isQuality {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+		format := ""
+		switch mediaType {
+		case "image/png", "image/*", "*/*":
+			format = "png"
+		case "image/svg+xml":
+			format = "svg"
+		}
+		if format != "" && quality > bestQuality {
+			bestFormat, bestQuality = format, quality
+		}
+	}
+	return bestFormat, bestFormat != ""
+}
+
+// The format parameter overrides the Accept header
+func parsePathImageRendering(c echo.Context) (pathImageRendering, error) {
+	var rendering pathImageRendering
+	var err error
+	if rendering.width, err = parsePathImageSize(c, "w"); err != nil {
+		return rendering, err
+	}
+	if rendering.height, err = parsePathImageSize(c, "h"); err != nil {
+		return rendering, err
+	}
+
+	rendering.format = strings.ToLower(c.QueryParam("format"))
+	if rendering.format == "" {
+		var isAcceptable bool
+		rendering.format, isAcceptable = negotiatePathImageFormat(c.Request().Header.Get(echo.HeaderAccept))
+		if !isAcceptable {
+			return rendering, echo.NewHTTPError(http.StatusNotAcceptable, "Path images are available as image/png and image/svg+xml")
+		}
+	}
+	if _, isKnown := pathImageContentTypes[rendering.format]; !isKnown {
+		return rendering, echo.NewHTTPError(http.StatusBadRequest, "Unknown format "+strconv.Quote(rendering.format)+", use png or svg")
+	}
+	return rendering, nil
+}
+
+// Same tag for the same saved image and rendering, saving an image again changes it
+func getPathImageETag(pathImage PathImageInfo, rendering pathImageRendering) string {
+	etag := fmt.Sprintf("path-%d-%d-%x", pathImage.RobotId, pathImage.StatusHistoryLength, pathImage.SavedAt.UnixNano())
+	if !rendering.isOriginal() {
+		etag += fmt.Sprintf("-%dx%d.%s", rendering.width, rendering.height, rendering.format)
+	}
+	return `"` + etag + `"`
+}
+
+func isPathImageNotModified(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Statuses of the robot when the image was saved, statuses are never changed once appended.
+// None when the robot was deleted since the image was looked up.
+func getPathImageStatuses(pathImage PathImageInfo) []*RobotStatus {
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	if robots[pathImage.RobotId] == nil {
+		return nil
+	}
+	statuses := robots[pathImage.RobotId].StatusHistory
+	if pathImage.StatusHistoryLength < len(statuses) {
+		statuses = statuses[:pathImage.StatusHistoryLength]
+	}
+	return statuses
+}
+
+// Decodes the stored image and renders it at the requested size, keeping the aspect ratio when only one size is given.
+// SVG is drawn from the robot statuses instead, so that it scales without losing quality.
+func renderPathImage(pathImage PathImageInfo, rendering pathImageRendering) ([]byte, error) {
+	if rendering.format == "svg" {
+		return maps.RenderPathSvg(getPathImageStatuses(pathImage), staticMapOptions, rendering.width, rendering.height), nil
+	}
+	file, err := os.Open(pathImage.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := rendering.width, rendering.height
+	bounds := img.Bounds()
+	if width == 0 && height != 0 {
+		width = (bounds.Dx()*height + bounds.Dy()/2) / bounds.Dy()
+	} else if height == 0 && width != 0 {
+		height = (bounds.Dy()*width + bounds.Dx()/2) / bounds.Dx()
+	}
+	if width > 0 && height > 0 && (width != bounds.Dx() || height != bounds.Dy()) {
+		img = maps.ResizeImage(img, width, height)
+	}
+
+	var buffer bytes.Buffer
+	err = png.Encode(&buffer, img)
+	return buffer.Bytes(), err
+}
+
+// Conditional and range requests are handled by http.ServeContent from the ETag and the save time
+func servePathImage(c echo.Context, pathImage PathImageInfo, rendering pathImageRendering, cacheControl string) error {
+	etag := getPathImageETag(pathImage, rendering)
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", cacheControl)
+	header.Set(echo.HeaderVary, echo.HeaderAccept)
+	// Checked first to skip the rendering, http.ServeContent checks it again along with If-Modified-Since
+	if isPathImageNotModified(c.Request().Header.Get("If-None-Match"), etag) {
+		header.Set(echo.HeaderLastModified, pathImage.SavedAt.UTC().Format(http.TimeFormat))
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	if rendering.isOriginal() {
+		file, err := os.Open(pathImage.Path)
+		if err != nil {
+			// Removed by the retention limits since it was looked up
+			return echo.NewHTTPError(http.StatusNotFound, "Path image was removed")
+		}
+		defer file.Close()
+		header.Set(echo.HeaderContentType, pathImageContentTypes[rendering.format])
+		http.ServeContent(c.Response(), c.Request(), "", pathImage.SavedAt, file)
+		return nil
+	}
+	content, err := renderPathImage(pathImage, rendering)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not render the path image : "+err.Error())
+	}
+	header.Set(echo.HeaderContentType, pathImageContentTypes[rendering.format])
+	http.ServeContent(c.Response(), c.Request(), "", pathImage.SavedAt, bytes.NewReader(content))
+	return nil
+}
+
+func getLatestPathImageInfo(c echo.Context) (PathImageInfo, error) {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return PathImageInfo{}, httpErr
+	}
+	pathImage, isSaved := pathImages.GetLatest(id)
+	if !isSaved {
+		return PathImageInfo{}, echo.NewHTTPError(http.StatusNotFound, "No path image of robot "+strconv.Itoa(id)+" yet")
+	}
+	return pathImage, nil
+}
+
+// Latest image, in the format of the format parameter or of the Accept header
+func getLatestPathImage(c echo.Context) error {
+	pathImage, httpErr := getLatestPathImageInfo(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	rendering, httpErr := parsePathImageRendering(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	return servePathImage(c, pathImage, rendering, LatestPathImageCacheControl)
+}
+
+// Latest image, always as PNG
+func getLatestPathImagePng(c echo.Context) error {
+	pathImage, httpErr := getLatestPathImageInfo(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	width, httpErr := parsePathImageSize(c, "w")
+	if httpErr != nil {
+		return httpErr
+	}
+	height, httpErr := parsePathImageSize(c, "h")
+	if httpErr != nil {
+		return httpErr
+	}
+	rendering := pathImageRendering{width: width, height: height, format: "png"}
+	return servePathImage(c, pathImage, rendering, LatestPathImageCacheControl)
+}
+
+// Image of a given status history length, only under the name the server gave it and while it is kept
+func getPathImageVersion(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	robotId, statusHistoryLength, err := ParsePathImageFilename(c.Param("filename"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if robotId != id {
+		return echo.NewHTTPError(http.StatusNotFound, "Path image of another robot")
+	}
+	pathImage, isKept := pathImages.Get(id, statusHistoryLength)
+	if !isKept {
+		return echo.NewHTTPError(http.StatusNotFound, "No path image of robot "+strconv.Itoa(id)+" at "+
+			strconv.Itoa(statusHistoryLength)+" statuses")
+	}
+	rendering, httpErr := parsePathImageRendering(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	return servePathImage(c, pathImage, rendering, PathImageVersionCacheControl)
+}
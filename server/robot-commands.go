@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	. "paltech.robot/robot"
+)
+
+// How often pending commands are checked for expiry
+const CommandExpiryCheckInterval = 10 * time.Second
+const DefaultCommandPollTimeoutSeconds = 30
+const MaxCommandPollTimeoutSeconds = 120
+
+var commandQueue = NewCommandQueue()
+
+func parseRegisteredRobotId(c echo.Context) (int, error) {
+	id, httpErr := parseId(c)
+	if httpErr != nil {
+		return 0, httpErr
+	}
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	if id < 0 || id >= len(robots) || robots[id] == nil {
+		return 0, echo.NewHTTPError(http.StatusNotFound, "No robot with ID "+strconv.Itoa(id))
+	}
+	return id, nil
+}
+
+func acknowledgeCommands(robotId int, acknowledgements []CommandAcknowledgement) {
+	for _, acknowledgement := range acknowledgements {
+		if err := commandQueue.Acknowledge(robotId, acknowledgement); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func enqueueRobotCommand(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	request := new(CommandRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+	if request.IssuedBy == "" {
+		request.IssuedBy = "api"
+	}
+
+	command, err := commandQueue.Enqueue(id, *request)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	fmt.Println("Queued command", command.Id, command.Type, "for robot", id)
+	return c.JSON(http.StatusCreated, command)
+}
+
+func getRobotCommands(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	return c.JSON(http.StatusOK, commandQueue.GetCommands(id))
+}
+
+func getRobotCommand(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	commandId, err := strconv.Atoi(c.Param("command_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Command ID must be an integer")
+	}
+	command, err := commandQueue.GetCommand(id, commandId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return c.JSON(http.StatusOK, command)
+}
+
+// Long poll for robots, answers as soon as commands are pending or after ?timeout_seconds=, 30 by default
+func pollRobotCommands(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	timeoutSeconds := DefaultCommandPollTimeoutSeconds
+	if timeoutParam := c.QueryParam("timeout_seconds"); timeoutParam != "" {
+		parsedTimeout, err := strconv.Atoi(timeoutParam)
+		if err != nil || parsedTimeout < 0 || parsedTimeout > MaxCommandPollTimeoutSeconds {
+			return echo.NewHTTPError(
+				http.StatusBadRequest,
+				"Poll timeout must be an integer between 0 and "+strconv.Itoa(MaxCommandPollTimeoutSeconds)+" seconds",
+			)
+		}
+		timeoutSeconds = parsedTimeout
+	}
+	return c.JSON(http.StatusOK, commandQueue.WaitPending(id, time.Duration(timeoutSeconds)*time.Second))
+}
+
+// For robots polling their commands, the others acknowledge them in their next status
+func postCommandAcknowledgements(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+	var acknowledgements []CommandAcknowledgement
+	if err := c.Bind(&acknowledgements); err != nil {
+		return err
+	}
+	acknowledgeCommands(id, acknowledgements)
+	return c.JSON(http.StatusOK, commandQueue.GetCommands(id))
+}
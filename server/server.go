@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"paltech.config/config"
 	. "paltech.robot/robot"
 	. "paltech.telegram_bot/telegram_bot"
 )
@@ -17,33 +18,132 @@ import (
 // go mod edit -replace paltech.robot/robot=../robot
 
 const RobotUpdateTimeoutMilliseconds = 10500
+const RobotTimeoutGracePeriodMilliseconds = 2000
 const RobotPeriodicUpdatesIntervalSeconds = 20
+const DefaultRobotClass = "default"
 
+var serverConfig *config.Config
+var staticMapOptions *StaticMapOptions
 var telegramBot *TelegramBot
 var robots = make([]*Robot, 0, 5)
 var robotsUpdateTimers = make([]*time.Timer, 0, 5)
 var robotsTimeoutTimers = make([]*time.Timer, 0, 5)
 var hasRobotTimedOut = make([]bool, 0, 5)
+var isRobotIdle = make([]bool, 0, 5)
+var idleRules = DefaultIdleRules
+var idleRulesMutex sync.Mutex
+var robotsLivenessPolicies = make([]LivenessPolicy, 0, 5)
+var robotClassesLivenessPolicies = map[string]LivenessPolicy{
+	DefaultRobotClass: {
+		UpdateTimeoutMilliseconds:      RobotUpdateTimeoutMilliseconds,
+		GracePeriodMilliseconds:        RobotTimeoutGracePeriodMilliseconds,
+		PeriodicUpdatesIntervalSeconds: RobotPeriodicUpdatesIntervalSeconds,
+	},
+}
 var robotsMutex sync.Mutex
 var nextRobotId = 0
 
 func main() {
+	serverConfig = config.MustLoadFromCommandLine()
+	if serverConfig.Telegram.ApiKey == "" {
+		log.Fatal("A Telegram API key is required to run the server")
+	}
+	staticMapOptions = &StaticMapOptions{
+		ApiKey:          serverConfig.Maps.ApiKey,
+		CenterLatitude:  serverConfig.Maps.CenterLatitude,
+		CenterLongitude: serverConfig.Maps.CenterLongitude,
+		Zoom:            serverConfig.Maps.Zoom,
+		SizePixels:      serverConfig.Maps.SizePixels,
+	}
+
 	e := echo.New()
 	e.POST("/register-robot", registerRobot)
 	e.POST("/update-robot/:id", updateRobot)
-	e.GET("/path/:filename", getPathImage)
+	e.DELETE("/robots/:id", deleteRobot)
+	e.GET("/robot-kinematics/:id", getRobotKinematics)
+	e.GET("/liveness-policy/:id", getLivenessPolicy)
+	e.PUT("/liveness-policy/:id", setLivenessPolicy)
+	e.GET("/class-liveness-policy/:class", getClassLivenessPolicy)
+	e.PUT("/class-liveness-policy/:class", setClassLivenessPolicy)
+	e.GET("/idle-rules", getIdleRules)
+	e.PUT("/idle-rules", setIdleRules)
+	e.GET("/alert-rules", getAlertRules)
+	e.PUT("/alert-rules", setAlertRules)
+	e.PUT("/alert-rules/:name", putAlertRule)
+	e.DELETE("/alert-rules/:name", deleteAlertRule)
+	e.GET("/alerts", getAlerts)
+	e.GET("/alerts/:id", getRobotAlerts)
+	e.POST("/alerts/:id/:rule/acknowledge", acknowledgeAlert)
+	e.POST("/alerts/:id/:rule/snooze", snoozeAlert)
+	e.POST("/robot-commands/:id", enqueueRobotCommand)
+	e.GET("/robot-commands/:id", getRobotCommands)
+	e.GET("/robot-commands/:id/poll", pollRobotCommands)
+	e.POST("/robot-commands/:id/acknowledgements", postCommandAcknowledgements)
+	e.GET("/robot-commands/:id/:command_id", getRobotCommand)
+	e.GET("/robots/:id/path", getLatestPathImage)
+	e.GET("/robots/:id/path.png", getLatestPathImagePng)
+	e.GET("/robots/:id/path-images/:filename", getPathImageVersion)
+	e.GET("/fleet-map.png", getFleetMap)
+	e.GET("/robot-coverage/:id", getRobotCoverage)
+	e.GET("/robot-coverage/:id/heatmap.png", getRobotCoverageHeatmap)
+	e.GET("/robot-coverage/:id/grid.geojson", getRobotCoverageGrid)
+	e.GET("/stats", getStats)
 
 	var err error
-	telegramBot, err = NewTelegramBot("TELEGRAMKEY")
+	alertManager, err = NewAlertManager(getAlertRulesFromConfig(serverConfig.Alerts.Rules))
+	if err != nil {
+		log.Fatal(err)
+	}
+	alertManager.EscalationDelay = time.Duration(serverConfig.Alerts.EscalationDelaySeconds) * time.Second
+	coverageSettings, err := getCoverageSettingsFromConfig(&serverConfig.Coverage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	coverageCache = NewCoverageCache(coverageSettings)
+	if serverConfig.Server.CleanPathImagesOnStart {
+		if err := RemoveLeftoverPathImages(serverConfig.Server.PathImagesDirectory); err != nil {
+			log.Fatal(err)
+		}
+	}
+	pathImages, err = NewPathImageStore(serverConfig.Server.PathImagesDirectory, getPathImageRetentionFromConfig(&serverConfig.Server))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	telegramAccess, err := NewAccessControl(serverConfig.Telegram.AccessFile, serverConfig.Telegram.AdminIds)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(serverConfig.Telegram.AdminIds) == 0 {
+		fmt.Println("No Telegram admin configured, join requests can not be approved from Telegram")
+	}
+
+	telegramBot, err = NewTelegramBot(serverConfig.Telegram.ApiKey, telegramAccess)
     if err != nil {
         log.Panic(err)
 		return
     }
 	telegramBot.Robots = &robots
 	telegramBot.RobotsMutex = &robotsMutex
+	telegramBot.Alerts = alertManager
+	telegramBot.Commands = commandQueue
+	commandQueue.Notifier = telegramBot
+	go commandQueue.WatchExpiry(CommandExpiryCheckInterval)
+	telegramBot.EscalationChatIds = serverConfig.Telegram.EscalationChatIds
+	telegramBot.LiveStatus = serverConfig.Telegram.LiveStatusMessages
+	telegramBot.MapOptions = staticMapOptions
+	telegramBot.PathImages = pathImages
+	telegramBot.Coverage = coverageCache
+	telegramBot.DigestInterval = time.Duration(serverConfig.Telegram.DigestIntervalMinutes) * time.Minute
+	if serverConfig.Telegram.DailySummaryTime != "" {
+		// Already validated with the configuration
+		telegramBot.DailySummaryTime, _ = time.Parse(config.DailySummaryTimeLayout, serverConfig.Telegram.DailySummaryTime)
+	}
+	alertManager.Notifier = telegramBot
+	go alertManager.WatchTimers(AlertTimersCheckInterval)
 
 	telegramBot.ListenAndServe()
-	e.Logger.Fatal(e.Start(":1323"))
+	e.Logger.Fatal(e.Start(serverConfig.Server.ListenAddress))
 }
 
 func parseId(c echo.Context) (int, error) {
@@ -57,64 +157,145 @@ func parseId(c echo.Context) (int, error) {
 
 func timeout(robotId int) {
 	<-robotsTimeoutTimers[robotId].C
-	fmt.Println("\nRobot", robotId, "timed out")
-	if robotsUpdateTimers[robotId] != nil {
-		robotsUpdateTimers[robotId].Stop()
+	if !isRobotRegistered(robotId) {
+		return
 	}
+	fmt.Println("\nRobot", robotId, "timed out")
+	stopPeriodicUpdateTimerForRobot(robotId)
 	// TODO Maybe add synchronization mechanics here, per robot
 	hasRobotTimedOut[robotId] = true
-	telegramBot.SendTimeoutMessage(robotId)
+	policy := getLivenessPolicyForRobot(robotId)
+	condition := "No update received for " + policy.GetTimeoutDuration().String()
+	alertManager.Raise(robotId, TimeoutAlertName, condition, SeverityCritical)
 }
 
-func periodicUpdate(robotId int) {
-	<-robotsUpdateTimers[robotId].C
+func periodicUpdate(robotId int, timer *time.Timer) {
+	robotsMutex.Lock()
+	// A timer that fired while being stopped or replaced must not start a second chain of updates
+	isCurrentTimer := robotsUpdateTimers[robotId] == timer
+	robotsMutex.Unlock()
+	if !isCurrentTimer {
+		return
+	}
 	telegramBot.SendPeriodicUpdateForRobot(robotId, false)
 	resetPeriodicUpdateTimerForRobot(robotId)
 }
 
+func isRobotRegistered(robotId int) bool {
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	return robots[robotId] != nil
+}
+
+func getLivenessPolicyForRobot(robotId int) LivenessPolicy {
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	return robotsLivenessPolicies[robotId]
+}
+
 func resetTimeoutTimerForRobot(robotId int) {
+	policy := getLivenessPolicyForRobot(robotId)
 	robotsTimeoutTimers[robotId].Stop()
 	hasRobotTimedOut[robotId] = false
-	robotsTimeoutTimers[robotId] = time.NewTimer(time.Duration(RobotUpdateTimeoutMilliseconds) * time.Millisecond)
+	robotsTimeoutTimers[robotId] = time.NewTimer(policy.GetTimeoutDuration())
 	fmt.Println("Reset timeout timer for robot", robotId)
 	go timeout(robotId)
 }
 
+// Replaces the running timer, if any. The update runs in the timer's own goroutine, so a stopped timer leaves none behind.
 func resetPeriodicUpdateTimerForRobot(robotId int) {
-	robotsUpdateTimers[robotId] = time.NewTimer(time.Duration(RobotPeriodicUpdatesIntervalSeconds) * time.Second)
-	go periodicUpdate(robotId)
+	policy := getLivenessPolicyForRobot(robotId)
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	if robotsUpdateTimers[robotId] != nil {
+		robotsUpdateTimers[robotId].Stop()
+	}
+	var timer *time.Timer
+	// periodicUpdate takes the mutex first, so it sees the timer assigned
+	timer = time.AfterFunc(policy.GetPeriodicUpdatesInterval(), func() { periodicUpdate(robotId, timer) })
+	robotsUpdateTimers[robotId] = timer
+}
+
+func stopPeriodicUpdateTimerForRobot(robotId int) {
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	if robotsUpdateTimers[robotId] != nil {
+		robotsUpdateTimers[robotId].Stop()
+		robotsUpdateTimers[robotId] = nil
+	}
+}
+
+func hasPeriodicUpdateTimer(robotId int) bool {
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	return robotsUpdateTimers[robotId] != nil
+}
+
+
+func updateIdleState(robotId int, robot *Robot) {
+	idleRulesMutex.Lock()
+	idleReasons := robot.DetectIdle(&idleRules)
+	idleRulesMutex.Unlock()
+
+	if len(idleReasons) > 0 && !isRobotIdle[robotId] {
+		fmt.Println("Robot", robotId, "is idle :", idleReasons)
+		isRobotIdle[robotId] = true
+		telegramBot.SendIdleMessage(robotId, idleReasons)
+	} else if len(idleReasons) == 0 && isRobotIdle[robotId] {
+		fmt.Println("Robot", robotId, "is not idle anymore")
+		isRobotIdle[robotId] = false
+		telegramBot.SendIdleClearedMessage(robotId)
+	}
 }
 
+func checkStatusVersion(robotId int, status *RobotStatus) {
+	if status.GetVersion() > CurrentStatusVersion {
+		fmt.Println("Robot", robotId, "sends status version", status.GetVersion(), ", fields unknown to this server are ignored")
+	}
+}
 
 func registerRobot(c echo.Context) error {
 	robot := new(Robot)
 
-	parsedInitialStatus := new(RobotStatus)
-	if err := c.Bind(parsedInitialStatus); err != nil {
+	registration := new(RobotRegistration)
+	if err := c.Bind(registration); err != nil {
 		return err
 	}
+	if registration.RobotClass == "" {
+		registration.RobotClass = DefaultRobotClass
+	}
+
+	robotsMutex.Lock()
+	classPolicy, isClassKnown := robotClassesLivenessPolicies[registration.RobotClass]
+	if !isClassKnown {
+		robotsMutex.Unlock()
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown robot class " + registration.RobotClass)
+	}
+	policy := classPolicy.Negotiate(registration)
 
 	robotId := nextRobotId
 	nextRobotId++
 	robot.Id = robotId
-	robot.AppendStatus(parsedInitialStatus)
-
-	robotsMutex.Lock()
+	checkStatusVersion(robotId, &registration.RobotStatus)
+	robot.AppendStatus(&registration.RobotStatus)
 	robots = append(robots, robot)
+	robotsLivenessPolicies = append(robotsLivenessPolicies, policy)
 	
 	// fmt.Println("Created robot : ", robot.ToString())
 	robotsUpdateTimers = append(robotsUpdateTimers, nil)
 	robotsTimeoutTimers = append(
 		robotsTimeoutTimers, 
-		time.NewTimer(time.Duration(RobotUpdateTimeoutMilliseconds) * time.Millisecond),
+		time.NewTimer(policy.GetTimeoutDuration()),
 	)
 	hasRobotTimedOut = append(hasRobotTimedOut, false)
-	// Releasing the mutex after having created the timer entries to make we have sync
-	// between robots, robotsUpdateTimers, robotsTimeoutTimers and hasRobotTimedOut indexes
+	isRobotIdle = append(isRobotIdle, false)
+	// Releasing the mutex after having created the timer entries to make we have sync between robots,
+	// robotsLivenessPolicies, robotsUpdateTimers, robotsTimeoutTimers, hasRobotTimedOut and isRobotIdle indexes
 	robotsMutex.Unlock()
 
+	robotsRegisteredCount.Add(1)
 	go timeout(robotId)
-	fmt.Println("\nRegistered robot", robotId)
+	fmt.Println("\nRegistered robot", robotId, "with liveness policy", policy)
 	return c.JSON(http.StatusOK, robot.Id)
 }
 
@@ -126,34 +307,195 @@ func updateRobot(c echo.Context) error {
 
 	parsedStatus := new(RobotStatus)
 	if err := c.Bind(parsedStatus); err != nil {
+		updatesRejectedCount.Add(1)
 		return err
 	}
 
 	robotsMutex.Lock()
-	if id >= len(robots) {
+	if id < 0 || id >= len(robots) || robots[id] == nil {
+		robotsMutex.Unlock()
+		updatesRejectedCount.Add(1)
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
+	checkStatusVersion(id, parsedStatus)
 	robots[id].AppendStatus(parsedStatus)
 	var robotCopy Robot = *robots[id]
 	robotsMutex.Unlock()
+	updatesReceivedCount.Add(1)
 
 	fmt.Println("\nUpdated robot :", id)
-	result := c.String(http.StatusOK, "")
-	robotCopy.GenerateAndSavePathImage()
+	acknowledgeCommands(id, parsedStatus.Acknowledgements)
+	kinematics := robotCopy.GetLatestKinematics()
+	result := c.JSON(http.StatusOK, UpdateResponse{
+		Kinematics: kinematics,
+		Commands:   commandQueue.TakePending(id),
+	})
+	// Load tests skip the maps API to only measure the HTTP path
+	if c.QueryParam("skip_path_image") != "true" {
+		if err := pathImages.Save(&robotCopy, staticMapOptions); err != nil {
+			fmt.Println("Could not save the path image of robot", id, ":", err)
+		} else {
+			pathImagesGeneratedCount.Add(1)
+		}
+	}
+
+	if kinematics != nil && kinematics.HasAnomalies() {
+		fmt.Println("Robot", id, "anomalies :", kinematics.Anomalies)
+		telegramBot.SendAnomalyMessage(id, kinematics)
+	}
+	updateIdleState(id, &robotCopy)
+	alertManager.Evaluate(&robotCopy)
 
 	if hasRobotTimedOut[id] {
 		fmt.Println("Robot", id, "came back online")
+		alertManager.Resolve(id, TimeoutAlertName)
 		telegramBot.SendPeriodicUpdateForRobot(id, true)
 		resetPeriodicUpdateTimerForRobot(id)
 	}
-	if robotsUpdateTimers[id] == nil {
+	if !hasPeriodicUpdateTimer(id) {
 		resetPeriodicUpdateTimerForRobot(id)
 	}
 	resetTimeoutTimerForRobot(id)
 	return result
 }
 
-func getPathImage(c echo.Context) error {
-	filepath := "pathImages/" + c.Param("filename")
-	return c.File(filepath)
+// Deleted robots keep their slot so that the ids of the other robots and the per robot slices stay aligned.
+// Their ids are never given again.
+func deleteRobot(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	robotsMutex.Lock()
+	if robots[id] == nil {
+		// Deleted by a concurrent request
+		robotsMutex.Unlock()
+		return echo.NewHTTPError(http.StatusNotFound, "No robot with ID "+strconv.Itoa(id))
+	}
+	robots[id] = nil
+	robotsTimeoutTimers[id].Stop()
+	if robotsUpdateTimers[id] != nil {
+		robotsUpdateTimers[id].Stop()
+		robotsUpdateTimers[id] = nil
+	}
+	robotsMutex.Unlock()
+
+	alertManager.RemoveRobot(id)
+	commandQueue.RemoveRobot(id)
+	pathImages.RemoveRobot(id)
+	coverageCache.RemoveRobot(id)
+	fmt.Println("\nDeleted robot", id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getRobotKinematics(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	if robots[id] == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No robot with ID "+strconv.Itoa(id))
+	}
+	return c.JSON(http.StatusOK, robots[id].KinematicsHistory)
+}
+
+func getLivenessPolicy(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	return c.JSON(http.StatusOK, robotsLivenessPolicies[id])
+}
+
+func setLivenessPolicy(c echo.Context) error {
+	id, httpErr := parseRegisteredRobotId(c)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	parsedPolicy := new(LivenessPolicy)
+	if err := c.Bind(parsedPolicy); err != nil {
+		return err
+	}
+	if err := parsedPolicy.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	robotsMutex.Lock()
+	if robots[id] == nil {
+		robotsMutex.Unlock()
+		return echo.NewHTTPError(http.StatusNotFound, "No robot with ID "+strconv.Itoa(id))
+	}
+	robotsLivenessPolicies[id] = *parsedPolicy
+	isOnline := !hasRobotTimedOut[id]
+	hasUpdateTimer := robotsUpdateTimers[id] != nil
+	robotsMutex.Unlock()
+
+	fmt.Println("\nUpdated liveness policy of robot", id, ":", *parsedPolicy)
+	// Restarting the timers so the new policy applies right away, unless the robot is already offline
+	if isOnline {
+		resetTimeoutTimerForRobot(id)
+		if hasUpdateTimer {
+			resetPeriodicUpdateTimerForRobot(id)
+		}
+	}
+	return c.JSON(http.StatusOK, *parsedPolicy)
+}
+
+func getClassLivenessPolicy(c echo.Context) error {
+	robotsMutex.Lock()
+	defer robotsMutex.Unlock()
+	policy, isClassKnown := robotClassesLivenessPolicies[c.Param("class")]
+	if !isClassKnown {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	return c.JSON(http.StatusOK, policy)
+}
+
+// Creates the class if it does not exist yet. Only applies to robots registering afterwards.
+func setClassLivenessPolicy(c echo.Context) error {
+	parsedPolicy := new(LivenessPolicy)
+	if err := c.Bind(parsedPolicy); err != nil {
+		return err
+	}
+	if err := parsedPolicy.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	robotsMutex.Lock()
+	robotClassesLivenessPolicies[c.Param("class")] = *parsedPolicy
+	robotsMutex.Unlock()
+	fmt.Println("\nUpdated liveness policy of robot class", c.Param("class"), ":", *parsedPolicy)
+	return c.JSON(http.StatusOK, *parsedPolicy)
+}
+
+func getIdleRules(c echo.Context) error {
+	idleRulesMutex.Lock()
+	defer idleRulesMutex.Unlock()
+	return c.JSON(http.StatusOK, idleRules)
+}
+
+func setIdleRules(c echo.Context) error {
+	parsedRules := new(IdleRules)
+	if err := c.Bind(parsedRules); err != nil {
+		return err
+	}
+	if parsedRules.MinDisplacementMeters < 0 || parsedRules.MinSpeedMetersPerSecond < 0 ||
+		parsedRules.DisplacementWindowSeconds < 0 || parsedRules.WaypointProgressWindowSeconds < 0 ||
+		parsedRules.SpeedWindowSeconds < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Idle rules thresholds and windows must be positive")
+	}
+
+	idleRulesMutex.Lock()
+	idleRules = *parsedRules
+	idleRulesMutex.Unlock()
+	fmt.Println("\nUpdated idle rules :", *parsedRules)
+	return c.JSON(http.StatusOK, *parsedRules)
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Counters exposed on /stats, so load tests can measure the throughput seen by the server
+type ServerStats struct {
+	UptimeSeconds       float64 `json:"uptime_seconds"`
+	RobotsRegistered    int64   `json:"robots_registered"`
+	UpdatesReceived     int64   `json:"updates_received"`
+	UpdatesRejected     int64   `json:"updates_rejected"`
+	PathImagesGenerated int64   `json:"path_images_generated"`
+}
+
+var serverStartTime = time.Now()
+var robotsRegisteredCount atomic.Int64
+var updatesReceivedCount atomic.Int64
+var updatesRejectedCount atomic.Int64
+var pathImagesGeneratedCount atomic.Int64
+
+func getStats(c echo.Context) error {
+	stats := ServerStats{
+		UptimeSeconds:       time.Since(serverStartTime).Seconds(),
+		RobotsRegistered:    robotsRegisteredCount.Load(),
+		UpdatesReceived:     updatesReceivedCount.Load(),
+		UpdatesRejected:     updatesRejectedCount.Load(),
+		PathImagesGenerated: pathImagesGeneratedCount.Load(),
+	}
+	return c.JSON(http.StatusOK, stats)
+}
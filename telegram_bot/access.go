@@ -0,0 +1,222 @@
+package telegram_bot
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Role string
+
+// Each role can do everything the previous ones can
+const (
+	RoleNone     Role = ""
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+func (role Role) getLevel() int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+func (role Role) Includes(other Role) bool {
+	return role.getLevel() >= other.getLevel()
+}
+
+func ParseRole(value string) (Role, error) {
+	role := Role(value)
+	if role.getLevel() == 0 {
+		return RoleNone, errors.New("Unknown role " + value + ", expected viewer, operator or admin")
+	}
+	return role, nil
+}
+
+// Sent with /start by chats that are not allowed yet
+type JoinRequest struct {
+	ChatId      int64     `json:"chat_id"`
+	UserId      int64     `json:"user_id"`
+	UserName    string    `json:"user_name"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+type GrantedAccess struct {
+	Id        int64     `json:"id"`
+	Role      Role      `json:"role"`
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// Saved as is in the access file
+type accessList struct {
+	Grants       map[int64]*GrantedAccess `json:"grants"`
+	JoinRequests map[int64]*JoinRequest   `json:"join_requests"`
+}
+
+// Allow-list of the Telegram users and chats, with their roles.
+// Ids can be user ids, granting the role in any chat, or chat ids, granting it to every member of the chat.
+// Admins from the configuration are always admins and are not saved.
+type AccessControl struct {
+	filePath string
+	adminIds []int64
+	list     accessList
+	mutex    sync.Mutex
+}
+
+func NewAccessControl(filePath string, adminIds []int64) (*AccessControl, error) {
+	access := &AccessControl{
+		filePath: filePath,
+		adminIds: adminIds,
+		list: accessList{
+			Grants:       make(map[int64]*GrantedAccess),
+			JoinRequests: make(map[int64]*JoinRequest),
+		},
+	}
+
+	content, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return access, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &access.list); err != nil {
+		return nil, errors.New("Could not parse " + filePath + " : " + err.Error())
+	}
+	if access.list.Grants == nil {
+		access.list.Grants = make(map[int64]*GrantedAccess)
+	}
+	if access.list.JoinRequests == nil {
+		access.list.JoinRequests = make(map[int64]*JoinRequest)
+	}
+	return access, nil
+}
+
+// Writes to a temporary file first so a crash never leaves a truncated access file
+func (access *AccessControl) save() error {
+	content, err := json.MarshalIndent(access.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporaryPath := filepath.Join(filepath.Dir(access.filePath), "."+filepath.Base(access.filePath)+".tmp")
+	if err := os.WriteFile(temporaryPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, access.filePath)
+}
+
+func (access *AccessControl) GetAdminIds() []int64 {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	adminIds := append([]int64{}, access.adminIds...)
+	for id, grant := range access.list.Grants {
+		if grant.Role == RoleAdmin {
+			adminIds = append(adminIds, id)
+		}
+	}
+	return adminIds
+}
+
+func (access *AccessControl) getRoleForId(id int64) Role {
+	for _, adminId := range access.adminIds {
+		if adminId == id {
+			return RoleAdmin
+		}
+	}
+	if grant, isGranted := access.list.Grants[id]; isGranted {
+		return grant.Role
+	}
+	return RoleNone
+}
+
+// Highest role granted to the user or to the chat the message comes from
+func (access *AccessControl) GetRole(userId int64, chatId int64) Role {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	role := access.getRoleForId(userId)
+	if chatRole := access.getRoleForId(chatId); chatRole.Includes(role) {
+		role = chatRole
+	}
+	return role
+}
+
+// Returns false if the chat already had a pending request
+func (access *AccessControl) AddJoinRequest(request JoinRequest) (bool, error) {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	if _, isPending := access.list.JoinRequests[request.ChatId]; isPending {
+		return false, nil
+	}
+	access.list.JoinRequests[request.ChatId] = &request
+	return true, access.save()
+}
+
+func (access *AccessControl) GetJoinRequests() []JoinRequest {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	requests := make([]JoinRequest, 0, len(access.list.JoinRequests))
+	for _, request := range access.list.JoinRequests {
+		requests = append(requests, *request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestedAt.Before(requests[j].RequestedAt) })
+	return requests
+}
+
+// Grants the role to the id, approving its join request if there is one
+func (access *AccessControl) Grant(id int64, role Role, grantedBy string) error {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	access.list.Grants[id] = &GrantedAccess{
+		Id:        id,
+		Role:      role,
+		GrantedBy: grantedBy,
+		GrantedAt: time.Now(),
+	}
+	delete(access.list.JoinRequests, id)
+	return access.save()
+}
+
+func (access *AccessControl) RejectJoinRequest(chatId int64) error {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	if _, isPending := access.list.JoinRequests[chatId]; !isPending {
+		return errors.New("No pending join request from " + strconv.FormatInt(chatId, 10))
+	}
+	delete(access.list.JoinRequests, chatId)
+	return access.save()
+}
+
+// Admins from the configuration can not be revoked
+func (access *AccessControl) Revoke(id int64) error {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	if _, isGranted := access.list.Grants[id]; !isGranted {
+		return errors.New(strconv.FormatInt(id, 10) + " has no granted role")
+	}
+	delete(access.list.Grants, id)
+	return access.save()
+}
+
+func (access *AccessControl) GetGrants() []GrantedAccess {
+	access.mutex.Lock()
+	defer access.mutex.Unlock()
+	grants := make([]GrantedAccess, 0, len(access.list.Grants))
+	for _, grant := range access.list.Grants {
+		grants = append(grants, *grant)
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].GrantedAt.Before(grants[j].GrantedAt) })
+	return grants
+}
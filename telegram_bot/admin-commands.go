@@ -0,0 +1,199 @@
+package telegram_bot
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback data of the join request buttons is "<action>:<chat id>:<role>"
+const (
+	callbackActionApprove = "approve"
+	callbackActionReject  = "reject"
+)
+
+func formatJoinRequest(request JoinRequest) string {
+	text := "Chat " + strconv.FormatInt(request.ChatId, 10) + " from " + request.UserName
+	if request.UserId != request.ChatId {
+		text += " (user " + strconv.FormatInt(request.UserId, 10) + ")"
+	}
+	return text + ", requested at " + request.RequestedAt.Format(time.DateTime)
+}
+
+func getJoinRequestKeyboard(request JoinRequest) tgbotapi.InlineKeyboardMarkup {
+	chatId := strconv.FormatInt(request.ChatId, 10)
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Approve as viewer", callbackActionApprove+":"+chatId+":"+string(RoleViewer)),
+			tgbotapi.NewInlineKeyboardButtonData("Approve as operator", callbackActionApprove+":"+chatId+":"+string(RoleOperator)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Reject", callbackActionReject+":"+chatId+":"),
+		),
+	)
+}
+
+// Admins only receive join requests if they started a private chat with the bot
+func (bot *TelegramBot) sendJoinRequestToAdmins(request JoinRequest) {
+	for _, adminId := range bot.Access.GetAdminIds() {
+		requestMessage := tgbotapi.NewMessage(adminId, "🙋 New join request\n"+formatJoinRequest(request))
+		requestMessage.ReplyMarkup = getJoinRequestKeyboard(request)
+		bot.send(adminId, requestMessage)
+	}
+}
+
+func (bot *TelegramBot) requestAccess(incomingMessage *tgbotapi.Message) {
+	request := JoinRequest{
+		ChatId:      incomingMessage.Chat.ID,
+		UserName:    getUserName(incomingMessage.From),
+		RequestedAt: time.Now(),
+	}
+	if incomingMessage.From != nil {
+		request.UserId = incomingMessage.From.ID
+	}
+
+	isNew, err := bot.Access.AddJoinRequest(request)
+	if err != nil {
+		log.Println("Could not save the join request :", err)
+		bot.sendText(request.ChatId, "Sorry, I could not record your request, please try again later")
+		return
+	}
+	if !isNew {
+		bot.sendText(request.ChatId, "Your request is still waiting for an admin")
+		return
+	}
+	bot.sendText(request.ChatId, "You are not allowed to use this bot yet, your request was sent to the admins")
+	bot.sendJoinRequestToAdmins(request)
+}
+
+func (bot *TelegramBot) grantAccess(chatId int64, id int64, role Role, grantedBy string) {
+	if err := bot.Access.Grant(id, role, grantedBy); err != nil {
+		log.Println("Could not save the access list :", err)
+		bot.sendText(chatId, "Could not save the access list : "+err.Error())
+		return
+	}
+	bot.sendText(chatId, strconv.FormatInt(id, 10)+" is now "+string(role))
+	bot.registerCommandsForChat(id)
+	bot.addRecipient(id, id)
+	// Users granted a role directly may never have talked to the bot, which then drops the message
+	bot.sendText(id, "Your access was approved, you are "+string(role)+
+		". You will now receive periodic updates.\nSend /help to list the commands")
+}
+
+func (bot *TelegramBot) respondJoinRequests(incomingMessage *tgbotapi.Message) {
+	requests := bot.Access.GetJoinRequests()
+	if len(requests) == 0 {
+		bot.sendText(incomingMessage.Chat.ID, "No pending join request")
+		return
+	}
+	for _, request := range requests {
+		requestMessage := tgbotapi.NewMessage(incomingMessage.Chat.ID, formatJoinRequest(request))
+		requestMessage.ReplyMarkup = getJoinRequestKeyboard(request)
+		bot.send(incomingMessage.Chat.ID, requestMessage)
+	}
+}
+
+// Reads the chat or user id from the first command argument, answering the chat when it is missing or invalid
+func (bot *TelegramBot) getIdFromArguments(incomingMessage *tgbotapi.Message) (int64, []string, bool) {
+	arguments := strings.Fields(incomingMessage.CommandArguments())
+	if len(arguments) == 0 {
+		bot.sendText(incomingMessage.Chat.ID, "Which chat ? Send /"+incomingMessage.Command()+" <chat id>")
+		return 0, nil, false
+	}
+	id, err := strconv.ParseInt(arguments[0], 10, 64)
+	if err != nil {
+		bot.sendText(incomingMessage.Chat.ID, "I'm having trouble reading "+arguments[0]+" as a chat id")
+		return 0, nil, false
+	}
+	return id, arguments[1:], true
+}
+
+func (bot *TelegramBot) respondApprove(incomingMessage *tgbotapi.Message) {
+	id, arguments, isValid := bot.getIdFromArguments(incomingMessage)
+	if !isValid {
+		return
+	}
+	role := RoleViewer
+	if len(arguments) > 0 {
+		parsedRole, err := ParseRole(arguments[0])
+		if err != nil {
+			bot.sendText(incomingMessage.Chat.ID, err.Error())
+			return
+		}
+		role = parsedRole
+	}
+	bot.grantAccess(incomingMessage.Chat.ID, id, role, getUserName(incomingMessage.From))
+}
+
+func (bot *TelegramBot) respondReject(incomingMessage *tgbotapi.Message) {
+	id, _, isValid := bot.getIdFromArguments(incomingMessage)
+	if !isValid {
+		return
+	}
+	if err := bot.Access.RejectJoinRequest(id); err != nil {
+		bot.sendText(incomingMessage.Chat.ID, err.Error())
+		return
+	}
+	bot.sendText(incomingMessage.Chat.ID, "Rejected the join request from "+strconv.FormatInt(id, 10))
+}
+
+func (bot *TelegramBot) respondRevoke(incomingMessage *tgbotapi.Message) {
+	id, _, isValid := bot.getIdFromArguments(incomingMessage)
+	if !isValid {
+		return
+	}
+	if err := bot.Access.Revoke(id); err != nil {
+		bot.sendText(incomingMessage.Chat.ID, err.Error())
+		return
+	}
+	bot.registerCommandsForChat(id)
+	message := "Revoked the access of " + strconv.FormatInt(id, 10)
+	if removed := bot.removeRecipientsWithoutAccess(); len(removed) > 0 {
+		message += ", unsubscribed the chats"
+		for _, chatId := range removed {
+			message += " " + strconv.FormatInt(chatId, 10)
+		}
+	}
+	bot.sendText(incomingMessage.Chat.ID, message)
+}
+
+func (bot *TelegramBot) respondUsers(incomingMessage *tgbotapi.Message) {
+	message := "Admins from the configuration : "
+	for i, adminId := range bot.Access.adminIds {
+		if i > 0 {
+			message += ", "
+		}
+		message += strconv.FormatInt(adminId, 10)
+	}
+	message += "\n"
+	for _, grant := range bot.Access.GetGrants() {
+		message += " - " + strconv.FormatInt(grant.Id, 10) + " : " + string(grant.Role)
+		message += ", granted by " + grant.GrantedBy + " on " + grant.GrantedAt.Format(time.DateOnly) + "\n"
+	}
+	bot.sendText(incomingMessage.Chat.ID, message)
+}
+
+func (bot *TelegramBot) respondApproveCallback(query *tgbotapi.CallbackQuery, chatId int64, roleName string) {
+	role, err := ParseRole(roleName)
+	if err != nil {
+		bot.answerCallbackQuery(query, err.Error())
+		return
+	}
+	bot.answerCallbackQuery(query, "Approved as "+string(role))
+	adminChatId := query.From.ID
+	if query.Message != nil {
+		adminChatId = query.Message.Chat.ID
+	}
+	bot.grantAccess(adminChatId, chatId, role, getUserName(query.From))
+}
+
+func (bot *TelegramBot) respondRejectCallback(query *tgbotapi.CallbackQuery, chatId int64, _ string) {
+	if err := bot.Access.RejectJoinRequest(chatId); err != nil {
+		bot.answerCallbackQuery(query, err.Error())
+		return
+	}
+	bot.answerCallbackQuery(query, "Rejected")
+}
@@ -0,0 +1,165 @@
+package telegram_bot
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	. "paltech.robot/robot"
+)
+
+const AlertSnoozeDuration = 30 * time.Minute
+
+// Callback data of the alert buttons is "<action>:<robot id>:<rule name>"
+const (
+	callbackActionAcknowledge = "ack"
+	callbackActionSnooze      = "snooze"
+	callbackActionStatus      = "status"
+)
+
+func formatSeverity(severity AlertSeverity) string {
+	switch severity {
+	case SeverityCritical:
+		return "🔴 CRITICAL"
+	case SeverityWarning:
+		return "🟠 Warning"
+	}
+	return "🔵 Info"
+}
+
+func formatAlert(alert Alert) string {
+	message := formatSeverity(alert.Severity) + " - Robot " + strconv.Itoa(alert.RobotId) + " : " + alert.RuleName + "\n"
+	message += " - Condition : " + alert.Condition + "\n"
+	if alert.RuleName != TimeoutAlertName {
+		message += " - Value : " + strconv.FormatFloat(alert.Value, 'f', 2, 64) + "\n"
+	}
+	message += " - Fired at : " + alert.FiredAt.Format(time.TimeOnly)
+	return message
+}
+
+func getCallbackData(action string, alert Alert) string {
+	return action + ":" + strconv.Itoa(alert.RobotId) + ":" + alert.RuleName
+}
+
+func getAlertKeyboard(alert Alert) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Acknowledge", getCallbackData(callbackActionAcknowledge, alert)),
+			tgbotapi.NewInlineKeyboardButtonData("Snooze 30m", getCallbackData(callbackActionSnooze, alert)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Show status", getCallbackData(callbackActionStatus, alert)),
+		),
+	)
+}
+
+// Left on acknowledged alerts, there is nothing more to do about them
+func getAcknowledgedAlertKeyboard(alert Alert) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Show status", getCallbackData(callbackActionStatus, alert)),
+		),
+	)
+}
+
+func (bot *TelegramBot) sendAlertMessage(chatId int64, text string, alert Alert) {
+	alertMessage := tgbotapi.NewMessage(chatId, text)
+	alertMessage.ReplyMarkup = getAlertKeyboard(alert)
+	bot.send(chatId, alertMessage)
+}
+
+func (bot *TelegramBot) sendToEscalationChats(text string) {
+	for _, chatId := range bot.EscalationChatIds {
+		bot.sendText(chatId, text)
+	}
+}
+
+// Also sent to the escalation chats once the alert was escalated
+func (bot *TelegramBot) broadcastAlertUpdate(alert Alert, text string) {
+	bot.broadcastText(text)
+	if alert.IsEscalated {
+		bot.sendToEscalationChats(text)
+	}
+}
+
+func (bot *TelegramBot) SendAlert(alert Alert) {
+	message := formatAlert(alert)
+	for _, recipientChatId := range bot.getRecipientChatIds() {
+		bot.sendAlertMessage(recipientChatId, message, alert)
+	}
+}
+
+func (bot *TelegramBot) SendAlertResolved(alert Alert) {
+	message := "✅ Resolved - Robot " + strconv.Itoa(alert.RobotId) + " : " + alert.RuleName
+	bot.broadcastAlertUpdate(alert, message)
+}
+
+func (bot *TelegramBot) SendAlertEscalated(alert Alert) {
+	message := "⏫ Escalated, nobody acknowledged it since " + alert.FiredAt.Format(time.TimeOnly) + "\n" + formatAlert(alert)
+	for _, chatId := range bot.EscalationChatIds {
+		bot.sendAlertMessage(chatId, message, alert)
+	}
+	if len(bot.EscalationChatIds) > 0 {
+		bot.broadcastText("Robot " + strconv.Itoa(alert.RobotId) + " alert " + alert.RuleName + " was escalated")
+	}
+}
+
+func getUserName(user *tgbotapi.User) string {
+	if user == nil {
+		return "unknown"
+	}
+	if user.UserName != "" {
+		return "@" + user.UserName
+	}
+	return user.FirstName
+}
+
+// Answers are shown to the user who pressed the button, failing to send them is not worth a panic
+func (bot *TelegramBot) answerCallbackQuery(query *tgbotapi.CallbackQuery, text string) {
+	if _, err := bot.apiBot.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		log.Println("Could not answer callback query :", err)
+	}
+}
+
+func (bot *TelegramBot) respondAcknowledgeCallback(query *tgbotapi.CallbackQuery, robotId int64, ruleName string) {
+	userName := getUserName(query.From)
+	alert, err := bot.Alerts.Acknowledge(int(robotId), ruleName, userName)
+	if err != nil {
+		bot.answerCallbackQuery(query, err.Error())
+		return
+	}
+	bot.answerCallbackQuery(query, "Acknowledged")
+	if query.Message != nil {
+		edit := tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, getAcknowledgedAlertKeyboard(alert))
+		bot.send(query.Message.Chat.ID, edit)
+	}
+	bot.broadcastAlertUpdate(alert, "👍 Robot "+strconv.Itoa(alert.RobotId)+" alert "+ruleName+" acknowledged by "+userName)
+}
+
+func (bot *TelegramBot) respondSnoozeCallback(query *tgbotapi.CallbackQuery, robotId int64, ruleName string) {
+	userName := getUserName(query.From)
+	alert, err := bot.Alerts.Snooze(int(robotId), ruleName, AlertSnoozeDuration, userName)
+	if err != nil {
+		bot.answerCallbackQuery(query, err.Error())
+		return
+	}
+	bot.answerCallbackQuery(query, "Snoozed until "+alert.SnoozedUntil.Format(time.TimeOnly))
+	bot.broadcastAlertUpdate(alert, "💤 Robot "+strconv.Itoa(alert.RobotId)+" alert "+ruleName+" snoozed by "+userName+
+		" until "+alert.SnoozedUntil.Format(time.TimeOnly))
+}
+
+func (bot *TelegramBot) respondStatusCallback(query *tgbotapi.CallbackQuery, robotId int64, _ string) {
+	bot.answerCallbackQuery(query, "")
+	if query.Message == nil {
+		return
+	}
+	chatId := query.Message.Chat.ID
+	message, imagePath, err := bot.getUpdateMessageAndImagePathForRobot(int(robotId))
+	if err != nil {
+		bot.sendText(chatId, err.Error())
+		return
+	}
+	bot.sendText(chatId, message)
+	bot.sendImage(chatId, imagePath)
+}
@@ -0,0 +1,465 @@
+package telegram_bot
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	. "paltech.robot/robot"
+)
+
+const DefaultHistoryDuration = time.Hour
+
+type botCommand struct {
+	name        string
+	arguments   string
+	description string
+	// Minimum role of the user or chat sending the command
+	role    Role
+	respond func(bot *TelegramBot, incomingMessage *tgbotapi.Message)
+}
+
+// Registry of the commands, used to dispatch messages, to write /help and to register them with Telegram.
+// /start is open to everyone so that unknown chats can request access.
+func getCommands() []botCommand {
+	return []botCommand{
+		{"start", "", "Receive periodic updates and alerts, or request access", RoleNone, (*TelegramBot).registerRecipient},
+		{"stop", "", "Stop receiving periodic updates and alerts", RoleViewer, (*TelegramBot).unregisterRecipient},
+		{"robots", "", "List the robots with their state and completion", RoleViewer, (*TelegramBot).respondRobots},
+		{"fleet", "", "Summary of the whole fleet with a map of every robot", RoleViewer, (*TelegramBot).respondFleet},
+		{"status", "<robot id>", "Status and path of a robot", RoleViewer, (*TelegramBot).respondStatusUpdate},
+		{"mission", "<robot id>", "Mission progress of a robot", RoleViewer, (*TelegramBot).respondMission},
+		{"history", "<robot id> [duration, e.g. 30m]", "What a robot did recently, over the last hour by default", RoleViewer, (*TelegramBot).respondHistory},
+		{"where", "<robot id>", "Location of a robot", RoleViewer, (*TelegramBot).respondWhere},
+		{"pause", "<robot id>", "Stop a robot where it is", RoleOperator, (*TelegramBot).respondPause},
+		{"resume", "<robot id>", "Resume the mission of a paused or returning robot", RoleOperator, (*TelegramBot).respondResume},
+		{"abort", "<robot id>", "Stop the mission of a robot for good", RoleOperator, (*TelegramBot).respondAbort},
+		{"return", "<robot id>", "Send a robot back to where it started", RoleOperator, (*TelegramBot).respondReturnToBase},
+		{"skip_waypoint", "<robot id>", "Give up the current waypoint of a robot", RoleOperator, (*TelegramBot).respondSkipWaypoint},
+		{"report_interval", "<robot id> <seconds>", "Change how often a robot sends its status", RoleOperator, (*TelegramBot).respondReportInterval},
+		{"requests", "", "List the pending join requests", RoleAdmin, (*TelegramBot).respondJoinRequests},
+		{"approve", "<chat id> [viewer|operator|admin]", "Grant a role to a chat or user, viewer by default", RoleAdmin, (*TelegramBot).respondApprove},
+		{"reject", "<chat id>", "Reject a join request", RoleAdmin, (*TelegramBot).respondReject},
+		{"revoke", "<chat id>", "Remove the role of a chat or user", RoleAdmin, (*TelegramBot).respondRevoke},
+		{"users", "", "List the chats and users with their roles", RoleAdmin, (*TelegramBot).respondUsers},
+		{"help", "", "List the commands", RoleNone, (*TelegramBot).respondHelp},
+	}
+}
+
+func findCommand(name string) (botCommand, bool) {
+	for _, command := range getCommands() {
+		if command.name == name {
+			return command, true
+		}
+	}
+	return botCommand{}, false
+}
+
+func getTelegramCommands(role Role) []tgbotapi.BotCommand {
+	telegramCommands := make([]tgbotapi.BotCommand, 0)
+	for _, command := range getCommands() {
+		if role.Includes(command.role) {
+			telegramCommands = append(telegramCommands, tgbotapi.BotCommand{
+				Command:     command.name,
+				Description: command.description,
+			})
+		}
+	}
+	return telegramCommands
+}
+
+// Makes the commands autocomplete in Telegram clients. Everyone sees the viewer commands,
+// the chats of operators and admins also see the commands of their role.
+func (bot *TelegramBot) registerCommands() {
+	defaultCommands := tgbotapi.NewSetMyCommands(getTelegramCommands(RoleViewer)...)
+	if _, err := bot.apiBot.Request(defaultCommands); err != nil {
+		log.Println("Could not register the bot commands :", err)
+	}
+	privilegedIds := make(map[int64]bool)
+	for _, adminId := range bot.Access.GetAdminIds() {
+		privilegedIds[adminId] = true
+	}
+	for _, grant := range bot.Access.GetGrants() {
+		if grant.Role.Includes(RoleOperator) {
+			privilegedIds[grant.Id] = true
+		}
+	}
+	for id := range privilegedIds {
+		bot.registerCommandsForChat(id)
+	}
+}
+
+// Called whenever the role of a chat or user changes. Private chats have the id of their user.
+// Chats without a privileged role fall back to the viewer commands.
+func (bot *TelegramBot) registerCommandsForChat(chatId int64) {
+	role := bot.Access.GetRole(chatId, chatId)
+	scope := tgbotapi.NewBotCommandScopeChat(chatId)
+	var request tgbotapi.Chattable = tgbotapi.NewDeleteMyCommandsWithScope(scope)
+	if role.Includes(RoleOperator) {
+		request = tgbotapi.NewSetMyCommandsWithScope(scope, getTelegramCommands(role)...)
+	}
+	// Fails for users who never talked to the bot, registerCommands tries again at the next start
+	if _, err := bot.apiBot.Request(request); err != nil {
+		log.Println("Could not register the bot commands of chat", chatId, ":", err)
+	}
+}
+
+// Only lists the commands the role can use
+func getHelpText(role Role) string {
+	text := "Available commands :\n"
+	for _, command := range getCommands() {
+		if !role.Includes(command.role) {
+			continue
+		}
+		text += "/" + command.name
+		if command.arguments != "" {
+			text += " " + command.arguments
+		}
+		text += " - " + command.description + "\n"
+	}
+	return text
+}
+
+func (bot *TelegramBot) respondHelp(incomingMessage *tgbotapi.Message) {
+	role := bot.getRoleForMessage(incomingMessage)
+	if role == RoleNone {
+		bot.sendText(incomingMessage.Chat.ID, "You are not allowed to use this bot yet, send /start to request access")
+		return
+	}
+	bot.sendText(incomingMessage.Chat.ID, getHelpText(role))
+}
+
+func (bot *TelegramBot) getRoleForMessage(incomingMessage *tgbotapi.Message) Role {
+	var userId int64
+	if incomingMessage.From != nil {
+		userId = incomingMessage.From.ID
+	}
+	return bot.Access.GetRole(userId, incomingMessage.Chat.ID)
+}
+
+func (bot *TelegramBot) respondCommand(incomingMessage *tgbotapi.Message) {
+	command, isKnown := findCommand(incomingMessage.Command())
+	if !isKnown {
+		bot.respondHelp(incomingMessage)
+		return
+	}
+	role := bot.getRoleForMessage(incomingMessage)
+	if !role.Includes(command.role) {
+		log.Println("Denied /"+command.name+" to chat", incomingMessage.Chat.ID, "with role", role)
+		if role == RoleNone {
+			bot.sendText(incomingMessage.Chat.ID, "You are not allowed to use this bot yet, send /start to request access")
+		} else {
+			bot.sendText(incomingMessage.Chat.ID, "/"+command.name+" requires the "+string(command.role)+" role, you are "+string(role))
+		}
+		return
+	}
+	command.respond(bot, incomingMessage)
+}
+
+// Callback data of the inline buttons is "<action>:<id>:<argument>", the id being a robot or a chat id
+type callbackAction struct {
+	role    Role
+	respond func(bot *TelegramBot, query *tgbotapi.CallbackQuery, id int64, argument string)
+}
+
+func getCallbackActions() map[string]callbackAction {
+	return map[string]callbackAction{
+		callbackActionAcknowledge: {RoleOperator, (*TelegramBot).respondAcknowledgeCallback},
+		callbackActionSnooze:      {RoleOperator, (*TelegramBot).respondSnoozeCallback},
+		callbackActionStatus:      {RoleViewer, (*TelegramBot).respondStatusCallback},
+		callbackActionApprove:     {RoleAdmin, (*TelegramBot).respondApproveCallback},
+		callbackActionReject:      {RoleAdmin, (*TelegramBot).respondRejectCallback},
+	}
+}
+
+func (bot *TelegramBot) respondCallbackQuery(query *tgbotapi.CallbackQuery) {
+	parts := strings.SplitN(query.Data, ":", 3)
+	action, isKnown := callbackAction{}, false
+	if len(parts) == 3 {
+		action, isKnown = getCallbackActions()[parts[0]]
+	}
+	if !isKnown {
+		bot.answerCallbackQuery(query, "Unknown action")
+		return
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		bot.answerCallbackQuery(query, "Invalid id "+parts[1])
+		return
+	}
+
+	var userId, chatId int64
+	if query.From != nil {
+		userId = query.From.ID
+	}
+	if query.Message != nil {
+		chatId = query.Message.Chat.ID
+	}
+	role := bot.Access.GetRole(userId, chatId)
+	if !role.Includes(action.role) {
+		log.Println("Denied", parts[0], "button to user", userId, "with role", role)
+		bot.answerCallbackQuery(query, "This requires the "+string(action.role)+" role")
+		return
+	}
+	action.respond(bot, query, id, parts[2])
+}
+
+// Copy of the robot, safe to read without holding the robots mutex
+func (bot *TelegramBot) getRobot(id int) (Robot, error) {
+	bot.RobotsMutex.Lock()
+	defer bot.RobotsMutex.Unlock()
+	if id < 0 || id >= len(*bot.Robots) || (*bot.Robots)[id] == nil {
+		return Robot{}, errors.New("Hum, I can't find bot " + strconv.Itoa(id) + ", are you sure it was created ?")
+	}
+	return *(*bot.Robots)[id], nil
+}
+
+func (bot *TelegramBot) getRobots() []Robot {
+	bot.RobotsMutex.Lock()
+	defer bot.RobotsMutex.Unlock()
+	robots := make([]Robot, 0, len(*bot.Robots))
+	// Deleted robots are nil
+	for _, robot := range *bot.Robots {
+		if robot != nil {
+			robots = append(robots, *robot)
+		}
+	}
+	return robots
+}
+
+// Robots are offline while their timeout alert is active
+func (bot *TelegramBot) isRobotOnline(robotId int) bool {
+	return bot.Alerts == nil || !bot.Alerts.HasActiveAlert(robotId, TimeoutAlertName)
+}
+
+// Reads the robot id from the first command argument, answering the chat when it is missing or invalid
+func (bot *TelegramBot) getRobotFromArguments(incomingMessage *tgbotapi.Message) (Robot, []string, bool) {
+	arguments := strings.Fields(incomingMessage.CommandArguments())
+	chatId := incomingMessage.Chat.ID
+	if len(arguments) == 0 {
+		bot.sendText(chatId, "Which robot ? Send /"+incomingMessage.Command()+" <robot id>")
+		return Robot{}, nil, false
+	}
+	robotId, err := strconv.Atoi(arguments[0])
+	if err != nil {
+		bot.sendText(chatId, "I'm having trouble reading "+arguments[0]+" as an integer")
+		return Robot{}, nil, false
+	}
+	robot, err := bot.getRobot(robotId)
+	if err != nil {
+		bot.sendText(chatId, err.Error())
+		return Robot{}, nil, false
+	}
+	return robot, arguments[1:], true
+}
+
+func formatPercent(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'f', 0, 64) + "%"
+}
+
+// Garden shares are small early in a mission, whole percents would stay at 0%
+func formatCoverage(coverage Coverage) string {
+	text := strconv.FormatFloat(coverage.CoveredAreaSquareMeters, 'f', 0, 64) + "m²"
+	if coverage.GardenAreaSquareMeters > 0 {
+		text += " (" + strconv.FormatFloat(coverage.GardenCoveredRatio*100, 'f', 2, 64) + "% of " + coverage.GardenName + ")"
+	}
+	if coverage.OverlapAreaSquareMeters > 0 {
+		text += ", " + strconv.FormatFloat(coverage.OverlapAreaSquareMeters, 'f', 0, 64) + "m² passed over more than once"
+	}
+	return text
+}
+
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func formatCompletion(status *RobotStatus) string {
+	return strconv.Itoa(status.WaypointsReached) + "/" + strconv.Itoa(status.WaypointsTotal) +
+		" waypoints (" + formatPercent(status.GetCompletion()) + ")"
+}
+
+func (bot *TelegramBot) respondRobots(incomingMessage *tgbotapi.Message) {
+	robots := bot.getRobots()
+	if len(robots) == 0 {
+		bot.sendText(incomingMessage.Chat.ID, "No robot registered yet")
+		return
+	}
+
+	bot.sendText(incomingMessage.Chat.ID, bot.getRobotsList(robots))
+}
+
+var robotStateIcons = map[string]string{"online": "🟢", "offline": "🔴", "finished": "🏁"}
+
+func (bot *TelegramBot) getRobotState(robot *Robot) string {
+	if !bot.isRobotOnline(robot.Id) {
+		return "offline"
+	}
+	if robot.GetLatestStatus().IsMissionFinished() {
+		return "finished"
+	}
+	return "online"
+}
+
+func (bot *TelegramBot) getRobotsList(robots []Robot) string {
+	message := "Robots (" + strconv.Itoa(len(robots)) + ") :\n"
+	for _, robot := range robots {
+		latestStatus := robot.GetLatestStatus()
+		state := bot.getRobotState(&robot)
+		message += " - Robot " + strconv.Itoa(robot.Id) + " : " + robotStateIcons[state] + " " + state + ", " + formatCompletion(latestStatus)
+		if latestStatus.HasTelemetry() && latestStatus.Telemetry.CurrentTask != "" {
+			message += ", " + string(latestStatus.Telemetry.CurrentTask)
+		}
+		message += "\n"
+	}
+	return message
+}
+
+func (bot *TelegramBot) respondFleet(incomingMessage *tgbotapi.Message) {
+	robots := bot.getRobots()
+	bot.sendText(incomingMessage.Chat.ID, bot.getFleetSummary(robots))
+	if image := bot.renderFleetMap(robots); image != nil {
+		bot.sendFleetMap(incomingMessage.Chat.ID, image)
+	}
+}
+
+func (bot *TelegramBot) getFleetSummary(robots []Robot) string {
+	onlineCount, finishedCount := 0, 0
+	waypointsReached, waypointsTotal := 0, 0
+	distanceCovered := 0.0
+	batteryTotal, batteryCount := 0.0, 0
+	for _, robot := range robots {
+		latestStatus := robot.GetLatestStatus()
+		if bot.isRobotOnline(robot.Id) {
+			onlineCount++
+		}
+		if latestStatus.IsMissionFinished() {
+			finishedCount++
+		}
+		waypointsReached += latestStatus.WaypointsReached
+		waypointsTotal += latestStatus.WaypointsTotal
+		distanceCovered += latestStatus.DistanceCovered
+		if latestStatus.HasTelemetry() {
+			batteryTotal += latestStatus.Telemetry.BatteryPercent
+			batteryCount++
+		}
+	}
+
+	message := "Fleet summary :\n"
+	message += " - Robots : " + strconv.Itoa(len(robots)) + " (" + strconv.Itoa(onlineCount) + " online, "
+	message += strconv.Itoa(len(robots)-onlineCount) + " offline, " + strconv.Itoa(finishedCount) + " finished)\n"
+	message += " - Waypoints reached : " + strconv.Itoa(waypointsReached) + "/" + strconv.Itoa(waypointsTotal)
+	if waypointsTotal > 0 {
+		message += " (" + formatPercent(float64(waypointsReached)/float64(waypointsTotal)) + ")"
+	}
+	message += "\n - Distance covered : " + strconv.FormatFloat(distanceCovered, 'f', 1, 64) + "m\n"
+	if batteryCount > 0 {
+		message += " - Average battery : " + strconv.FormatFloat(batteryTotal/float64(batteryCount), 'f', 0, 64) + "%\n"
+	}
+	if bot.Alerts != nil {
+		activeAlerts := bot.Alerts.GetAlerts(true)
+		criticalCount := 0
+		for _, alert := range activeAlerts {
+			if alert.Severity == SeverityCritical {
+				criticalCount++
+			}
+		}
+		message += " - Active alerts : " + strconv.Itoa(len(activeAlerts)) + " (" + strconv.Itoa(criticalCount) + " critical)\n"
+	}
+	return message
+}
+
+func (bot *TelegramBot) respondMission(incomingMessage *tgbotapi.Message) {
+	robot, _, isFound := bot.getRobotFromArguments(incomingMessage)
+	if !isFound {
+		return
+	}
+	latestStatus := robot.GetLatestStatus()
+
+	message := "Mission of robot " + strconv.Itoa(robot.Id) + " :\n"
+	message += " - Completion : " + formatCompletion(latestStatus) + "\n"
+	message += " - Successful waypoints : " + strconv.Itoa(latestStatus.WaypointsSuccessful) + "/"
+	message += strconv.Itoa(latestStatus.WaypointsReached) + " (" + formatPercent(latestStatus.GetWaypointSuccessRate()) + ")\n"
+	message += " - Distance covered : " + strconv.FormatFloat(latestStatus.DistanceCovered, 'f', 1, 64) + "m\n"
+	message += " - Elapsed : " + formatSeconds(float64(robot.GetMissionElapsedSeconds())) + "\n"
+	if latestStatus.HasTelemetry() && latestStatus.Telemetry.CurrentTask != "" {
+		message += " - Current task : " + string(latestStatus.Telemetry.CurrentTask) + "\n"
+	}
+	if latestStatus.IsMissionFinished() {
+		message += " - Finished 🏁\n"
+	} else if remainingSeconds, isEstimated := robot.EstimateRemainingSeconds(); isEstimated {
+		message += " - Estimated remaining : " + formatSeconds(remainingSeconds) + "\n"
+	}
+	if bot.Coverage == nil {
+		bot.sendText(incomingMessage.Chat.ID, message)
+		return
+	}
+	grid := bot.Coverage.GetGrid(&robot)
+	message += " - Area worked : " + formatCoverage(grid.GetCoverage(bot.Coverage.Settings)) + "\n"
+	bot.sendText(incomingMessage.Chat.ID, message)
+	bot.sendCoverageHeatmap(incomingMessage.Chat.ID, robot.Id, grid)
+}
+
+func (bot *TelegramBot) respondHistory(incomingMessage *tgbotapi.Message) {
+	robot, arguments, isFound := bot.getRobotFromArguments(incomingMessage)
+	if !isFound {
+		return
+	}
+	chatId := incomingMessage.Chat.ID
+	duration := DefaultHistoryDuration
+	if len(arguments) > 0 {
+		parsedDuration, err := time.ParseDuration(arguments[0])
+		if err != nil || parsedDuration <= 0 {
+			bot.sendText(chatId, "I'm having trouble reading "+arguments[0]+" as a duration, try e.g. 30m or 2h")
+			return
+		}
+		duration = parsedDuration
+	}
+
+	statuses, kinematics := robot.GetHistorySince(int64(duration.Seconds()))
+	firstStatus := statuses[0]
+	latestStatus := statuses[len(statuses)-1]
+	elapsedSeconds := latestStatus.Timestamp - firstStatus.Timestamp
+
+	message := "History of robot " + strconv.Itoa(robot.Id) + " over the last " + duration.String()
+	if elapsedSeconds < int64(duration.Seconds()) {
+		message += " (only " + formatSeconds(float64(elapsedSeconds)) + " recorded)"
+	}
+	message += " :\n"
+	message += " - Updates : " + strconv.Itoa(len(statuses)-1) + "\n"
+	message += " - Distance covered : " + strconv.FormatFloat(latestStatus.DistanceCovered-firstStatus.DistanceCovered, 'f', 1, 64) + "m\n"
+	message += " - Waypoints reached : " + strconv.Itoa(latestStatus.WaypointsReached-firstStatus.WaypointsReached)
+	message += " (" + strconv.Itoa(latestStatus.WaypointsSuccessful-firstStatus.WaypointsSuccessful) + " successful)\n"
+
+	gpsDistance, anomaliesCount := 0.0, 0
+	for _, currentKinematics := range kinematics {
+		gpsDistance += currentKinematics.GPSDistance
+		if currentKinematics.HasAnomalies() {
+			anomaliesCount++
+		}
+	}
+	if elapsedSeconds > 0 {
+		message += " - Average GPS speed : " + strconv.FormatFloat(gpsDistance/float64(elapsedSeconds), 'f', 2, 64) + "m/s\n"
+	}
+	message += " - Updates with anomalies : " + strconv.Itoa(anomaliesCount) + "\n"
+	bot.sendText(chatId, message)
+	bot.sendImage(chatId, bot.getPathImagePath(&robot))
+}
+
+func (bot *TelegramBot) respondWhere(incomingMessage *tgbotapi.Message) {
+	robot, _, isFound := bot.getRobotFromArguments(incomingMessage)
+	if !isFound {
+		return
+	}
+	chatId := incomingMessage.Chat.ID
+	latestStatus := robot.GetLatestStatus()
+
+	bot.send(chatId, tgbotapi.NewLocation(chatId, latestStatus.Latitude, latestStatus.Longitude))
+	heading := latestStatus.GetHeadingDegrees()
+	message := "Robot " + strconv.Itoa(robot.Id) + " is at " + strconv.FormatFloat(latestStatus.Latitude, 'f', 6, 64)
+	message += ", " + strconv.FormatFloat(latestStatus.Longitude, 'f', 6, 64)
+	message += ", heading " + strconv.FormatFloat(heading, 'f', 0, 64) + "° (" + GetCompassPoint(heading) + ")"
+	bot.sendText(chatId, message)
+}
@@ -0,0 +1,251 @@
+package telegram_bot
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram allows about 30 messages per second overall, one per second in a private chat
+// and 20 per minute in a group
+const GlobalMessageInterval = time.Second / 30
+const PrivateChatMessageInterval = time.Second
+const GroupChatMessageInterval = 3 * time.Second
+
+// Errors other than flood control are retried with a growing delay, then the message is dropped
+const MaxMessageDeliveryAttempts = 4
+const MessageRetryDelay = 2 * time.Second
+
+// The oldest messages of a chat are dropped beyond this, e.g. while Telegram is unreachable
+const MaxQueuedMessagesPerChat = 100
+
+// Passed to the delivery callback of the messages dropped to make room for newer ones
+var errMessageDropped = errors.New("Too many messages queued for the chat, the message was dropped")
+
+type outgoingMessage struct {
+	chatId    int64
+	chattable tgbotapi.Chattable
+	attempts  int
+	// A queued message with the same key in the same chat is replaced instead of sending both, e.g. two edits of a message
+	key string
+	// Called once the message was sent, or with the error once it was dropped.
+	// Messages dropped with their unreachable chat get the error of the message that found it unreachable.
+	onDelivered func(sent *tgbotapi.Message, err error)
+}
+
+// Sends the messages of the bot one at a time within the Telegram rate limits.
+// Every chat has its own queue, so a chat under flood control does not delay the others.
+type deliveryQueue struct {
+	messagesByChat map[int64][]*outgoingMessage
+	nextSendByChat map[int64]time.Time
+	nextSend       time.Time
+	// Being sent, it can not be replaced anymore
+	inFlight *outgoingMessage
+	mutex    sync.Mutex
+	// Wakes up the delivery loop when a message is queued
+	wakeUp chan struct{}
+	send   func(tgbotapi.Chattable) (tgbotapi.Message, error)
+	// Called when a chat blocked the bot or does not exist anymore, its messages are dropped
+	onChatUnreachable func(chatId int64)
+}
+
+func newDeliveryQueue(send func(tgbotapi.Chattable) (tgbotapi.Message, error), onChatUnreachable func(chatId int64)) *deliveryQueue {
+	return &deliveryQueue{
+		messagesByChat:    make(map[int64][]*outgoingMessage),
+		nextSendByChat:    make(map[int64]time.Time),
+		wakeUp:            make(chan struct{}, 1),
+		send:              send,
+		onChatUnreachable: onChatUnreachable,
+	}
+}
+
+// Group chat ids are negative
+func getChatMessageInterval(chatId int64) time.Duration {
+	if chatId < 0 {
+		return GroupChatMessageInterval
+	}
+	return PrivateChatMessageInterval
+}
+
+func (queue *deliveryQueue) enqueue(message *outgoingMessage) {
+	queue.mutex.Lock()
+	messages := queue.messagesByChat[message.chatId]
+	if message.key != "" {
+		for _, queuedMessage := range messages {
+			if queuedMessage.key == message.key && queuedMessage != queue.inFlight {
+				queuedMessage.chattable = message.chattable
+				queuedMessage.onDelivered = message.onDelivered
+				queuedMessage.attempts = 0
+				queue.mutex.Unlock()
+				return
+			}
+		}
+	}
+	var dropped *outgoingMessage
+	if len(messages) >= MaxQueuedMessagesPerChat {
+		log.Println("Too many messages queued for chat", message.chatId, ", dropping the oldest one")
+		// The message being sent is removed once its delivery ends
+		droppedIndex := 0
+		if messages[0] == queue.inFlight {
+			droppedIndex = 1
+		}
+		dropped = messages[droppedIndex]
+		messages = append(messages[:droppedIndex:droppedIndex], messages[droppedIndex+1:]...)
+	}
+	queue.messagesByChat[message.chatId] = append(messages, message)
+	queue.mutex.Unlock()
+
+	// Called without the queue mutex as it may queue messages too
+	if dropped != nil && dropped.onDelivered != nil {
+		dropped.onDelivered(nil, errMessageDropped)
+	}
+	select {
+	case queue.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// The message of the chat that can be sent the soonest, and when. The queue mutex must be held.
+func (queue *deliveryQueue) getNextMessage() (*outgoingMessage, time.Time, bool) {
+	var nextMessage *outgoingMessage
+	var nextSend time.Time
+	for chatId, messages := range queue.messagesByChat {
+		chatNextSend := queue.nextSendByChat[chatId]
+		if nextMessage == nil || chatNextSend.Before(nextSend) {
+			nextMessage = messages[0]
+			nextSend = chatNextSend
+		}
+	}
+	if nextMessage == nil {
+		return nil, time.Time{}, false
+	}
+	if queue.nextSend.After(nextSend) {
+		nextSend = queue.nextSend
+	}
+	return nextMessage, nextSend, true
+}
+
+// The message may already have been dropped to make room for newer ones. The queue mutex must be held.
+func (queue *deliveryQueue) removeMessage(message *outgoingMessage) {
+	messages := queue.messagesByChat[message.chatId]
+	for i, queuedMessage := range messages {
+		if queuedMessage == message {
+			messages = append(messages[:i:i], messages[i+1:]...)
+			break
+		}
+	}
+	if len(messages) == 0 {
+		delete(queue.messagesByChat, message.chatId)
+		return
+	}
+	queue.messagesByChat[message.chatId] = messages
+}
+
+func (queue *deliveryQueue) waitFor(delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-queue.wakeUp:
+	case <-timer.C:
+	}
+}
+
+func (queue *deliveryQueue) deliverMessages() {
+	for {
+		queue.mutex.Lock()
+		message, nextSend, hasMessage := queue.getNextMessage()
+		if !hasMessage {
+			queue.mutex.Unlock()
+			<-queue.wakeUp
+			continue
+		}
+		now := time.Now()
+		if nextSend.After(now) {
+			queue.mutex.Unlock()
+			queue.waitFor(nextSend.Sub(now))
+			continue
+		}
+		queue.nextSend = now.Add(GlobalMessageInterval)
+		queue.nextSendByChat[message.chatId] = now.Add(getChatMessageInterval(message.chatId))
+		queue.inFlight = message
+		message.attempts++
+		chattable := message.chattable
+		queue.mutex.Unlock()
+
+		sent, err := queue.send(chattable)
+		isFinished, isChatUnreachable, dropped := queue.handleDeliveryResult(message, err)
+		// Callbacks are called without the queue mutex as they may queue messages too
+		if isFinished && message.onDelivered != nil {
+			if err != nil {
+				message.onDelivered(nil, err)
+			} else {
+				message.onDelivered(&sent, nil)
+			}
+		}
+		for _, droppedMessage := range dropped {
+			if droppedMessage.onDelivered != nil {
+				droppedMessage.onDelivered(nil, err)
+			}
+		}
+		if isChatUnreachable {
+			queue.onChatUnreachable(message.chatId)
+		}
+	}
+}
+
+// File uploads, e.g. photos, report errors without their code, only with the description
+func isForbidden(apiErr *tgbotapi.Error) bool {
+	return apiErr.Code == http.StatusForbidden || strings.HasPrefix(apiErr.Message, "Forbidden:")
+}
+
+func isBadRequest(apiErr *tgbotapi.Error) bool {
+	return apiErr.Code == http.StatusBadRequest || strings.HasPrefix(apiErr.Message, "Bad Request:")
+}
+
+// Returns whether the message was sent or dropped, whether the chat is unreachable,
+// and the other messages of the chat dropped along with it
+func (queue *deliveryQueue) handleDeliveryResult(message *outgoingMessage, err error) (bool, bool, []*outgoingMessage) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.inFlight = nil
+	if err == nil {
+		queue.removeMessage(message)
+		return true, false, nil
+	}
+
+	var apiErr *tgbotapi.Error
+	isApiError := errors.As(err, &apiErr)
+	switch {
+	case isApiError && apiErr.RetryAfter > 0:
+		// Flood control, the message is sent again once Telegram allows it
+		retryAfter := time.Duration(apiErr.RetryAfter) * time.Second
+		log.Println("Telegram flood control on chat", message.chatId, ", retrying in", retryAfter)
+		queue.nextSendByChat[message.chatId] = time.Now().Add(retryAfter)
+		message.attempts--
+		return false, false, nil
+	case isApiError && (isForbidden(apiErr) || apiErr.Message == "Bad Request: chat not found"):
+		log.Println("Chat", message.chatId, "is unreachable, dropping its messages :", err)
+		dropped := make([]*outgoingMessage, 0)
+		for _, queuedMessage := range queue.messagesByChat[message.chatId] {
+			if queuedMessage != message {
+				dropped = append(dropped, queuedMessage)
+			}
+		}
+		delete(queue.messagesByChat, message.chatId)
+		delete(queue.nextSendByChat, message.chatId)
+		return true, true, dropped
+	case message.attempts >= MaxMessageDeliveryAttempts || (isApiError && isBadRequest(apiErr)):
+		// Bad requests fail the same way every time
+		log.Println("Could not send message to chat", message.chatId, "after", message.attempts, "attempts :", err)
+		queue.removeMessage(message)
+		return true, false, nil
+	}
+	log.Println("Could not send message to chat", message.chatId, ", retrying :", err)
+	queue.nextSendByChat[message.chatId] = time.Now().Add(time.Duration(message.attempts) * MessageRetryDelay)
+	return false, false, nil
+}
@@ -0,0 +1,178 @@
+package telegram_bot
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	. "paltech.robot/robot"
+	"paltech.robot/robot/maps"
+)
+
+const FleetMapFilename = "fleet.png"
+const CoverageHeatmapFilename = "coverage.png"
+const DailySummaryWindowSeconds = 24 * 60 * 60
+
+// Telegram rejects messages over 4096 characters, the first part of a digest also has its title and summary
+const MaxDigestTableLength = 3500
+
+func (bot *TelegramBot) IsDigestEnabled() bool {
+	return bot.DigestInterval > 0
+}
+
+// Digests are aligned on the local clock, e.g. every 15 minutes at :00, :15, :30 and :45,
+// or every 6 hours at 00:00, 06:00, 12:00 and 18:00 like the daily summary
+func getNextDigestTime(now time.Time, interval time.Duration) time.Time {
+	// Truncate aligns on UTC
+	_, offsetSeconds := now.Zone()
+	offset := time.Duration(offsetSeconds) * time.Second
+	return now.Add(offset).Truncate(interval).Add(interval).Add(-offset)
+}
+
+func getNextDailySummaryTime(now time.Time, summaryTime time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), summaryTime.Hour(), summaryTime.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Distance covered and waypoints reached over the window
+func getProgressSince(robot *Robot, windowSeconds int64) (float64, int) {
+	statuses, _ := robot.GetHistorySince(windowSeconds)
+	first, latest := statuses[0], statuses[len(statuses)-1]
+	return latest.DistanceCovered - first.DistanceCovered, latest.WaypointsReached - first.WaypointsReached
+}
+
+func formatDigestTable(table string) string {
+	return "<pre>" + html.EscapeString(table) + "</pre>"
+}
+
+// Monospace tables, only readable with the HTML parse mode. Large fleets are split in several tables
+// of at most MaxDigestTableLength characters, each with the header row.
+func (bot *TelegramBot) getDigestTables(robots []Robot, isDailySummary bool) []string {
+	header := fmt.Sprintf("%-5s %-13s %9s %-8s", "Robot", "Completion", "Distance", "State")
+	if isDailySummary {
+		header += fmt.Sprintf(" %9s %5s", "Last 24h", "WPs")
+	}
+	header += "\n"
+	tables := make([]string, 0, 1)
+	table := header
+	for _, robot := range robots {
+		latestStatus := robot.GetLatestStatus()
+		completion := strconv.Itoa(latestStatus.WaypointsReached) + "/" + strconv.Itoa(latestStatus.WaypointsTotal) +
+			" " + formatPercent(latestStatus.GetCompletion())
+		row := fmt.Sprintf("%-5d %-13s %8.0fm %-8s", robot.Id, completion, latestStatus.DistanceCovered, bot.getRobotState(&robot))
+		if isDailySummary {
+			distance, waypoints := getProgressSince(&robot, DailySummaryWindowSeconds)
+			row += fmt.Sprintf(" %+8.0fm %+5d", distance, waypoints)
+		}
+		row += "\n"
+		if table != header && len(formatDigestTable(table+row)) > MaxDigestTableLength {
+			tables = append(tables, formatDigestTable(table))
+			table = header
+		}
+		table += row
+	}
+	return append(tables, formatDigestTable(table))
+}
+
+// Returns nil when the map could not be rendered, the message is then sent without it
+func (bot *TelegramBot) renderFleetMap(robots []Robot) []byte {
+	if bot.MapOptions == nil || len(robots) == 0 {
+		return nil
+	}
+	image, err := maps.RenderFleetMapPng(robots, bot.MapOptions)
+	if err != nil {
+		log.Println("Could not render the fleet map :", err)
+		return nil
+	}
+	return image
+}
+
+func (bot *TelegramBot) sendFleetMap(chatId int64, image []byte) {
+	photo := tgbotapi.NewPhoto(chatId, tgbotapi.FileBytes{Name: FleetMapFilename, Bytes: image})
+	photo.Caption = "Fleet map at " + time.Now().Format(time.TimeOnly)
+	bot.send(chatId, photo)
+}
+
+func (bot *TelegramBot) sendCoverageHeatmap(chatId int64, robotId int, grid *CoverageGrid) {
+	if bot.MapOptions == nil || grid.IsEmpty() {
+		return
+	}
+	image, err := maps.RenderCoverageHeatmapPng(grid, bot.MapOptions)
+	if err != nil {
+		log.Println("Could not render the coverage heatmap :", err)
+		return
+	}
+	photo := tgbotapi.NewPhoto(chatId, tgbotapi.FileBytes{Name: CoverageHeatmapFilename, Bytes: image})
+	photo.Caption = "Passes of robot " + strconv.Itoa(robotId) + " over the ground it worked"
+	bot.send(chatId, photo)
+}
+
+func (bot *TelegramBot) sendDigest(isDailySummary bool) {
+	robots := bot.getRobots()
+	chatIds := bot.getRecipientChatIds()
+	if len(robots) == 0 || len(chatIds) == 0 {
+		return
+	}
+
+	title := "📋 Fleet digest, " + time.Now().Format(time.TimeOnly)
+	if isDailySummary {
+		title = "🗓 Daily summary, " + time.Now().Format(time.DateOnly)
+	}
+	tables := bot.getDigestTables(robots, isDailySummary)
+	texts := make([]string, 0, len(tables))
+	for i, table := range tables {
+		partTitle := title
+		if len(tables) > 1 {
+			partTitle += " (" + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(tables)) + ")"
+		}
+		text := "<b>" + html.EscapeString(partTitle) + "</b>\n"
+		if i == 0 {
+			text += html.EscapeString(bot.getFleetSummary(robots))
+		}
+		texts = append(texts, text+table)
+	}
+	image := bot.renderFleetMap(robots)
+
+	for _, chatId := range chatIds {
+		for _, text := range texts {
+			digestMessage := tgbotapi.NewMessage(chatId, text)
+			digestMessage.ParseMode = tgbotapi.ModeHTML
+			bot.send(chatId, digestMessage)
+		}
+		if image != nil {
+			bot.sendFleetMap(chatId, image)
+		}
+	}
+}
+
+func (bot *TelegramBot) watchDigests() {
+	for {
+		now := time.Now()
+		var nextDigest, nextDailySummary time.Time
+		next := now.Add(24 * time.Hour)
+		if bot.IsDigestEnabled() {
+			nextDigest = getNextDigestTime(now, bot.DigestInterval)
+			next = nextDigest
+		}
+		if !bot.DailySummaryTime.IsZero() {
+			nextDailySummary = getNextDailySummaryTime(now, bot.DailySummaryTime)
+			if nextDailySummary.Before(next) {
+				next = nextDailySummary
+			}
+		}
+
+		time.Sleep(time.Until(next))
+		// The daily summary replaces the digest due at the same time
+		if next.Equal(nextDailySummary) {
+			bot.sendDigest(true)
+		} else {
+			bot.sendDigest(false)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package telegram_bot
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Robot id of the fleet overview in the live messages
+const fleetOverviewRobotId = -1
+
+// The fleet overview changes with every robot update, it is edited at most this often
+const FleetOverviewUpdateInterval = time.Minute
+
+// Telegram limit of photo captions
+const MaxCaptionLength = 1024
+
+type liveMessageKey struct {
+	chatId  int64
+	robotId int
+}
+
+// Message of a chat edited on every periodic update instead of sending a new one
+type liveMessage struct {
+	// 0 until Telegram returned the sent message
+	messageId int
+	// Photos can only be edited into photos, and texts into texts
+	hasPhoto bool
+}
+
+func (key liveMessageKey) getQueueKey() string {
+	return "live:" + strconv.Itoa(key.robotId)
+}
+
+func truncateCaption(text string) string {
+	if len(text) <= MaxCaptionLength {
+		return text
+	}
+	// Cuts between UTF-8 characters
+	cutIndex := MaxCaptionLength - len("…")
+	for cutIndex > 0 && !isUtf8CharacterStart(text[cutIndex]) {
+		cutIndex--
+	}
+	return text[:cutIndex] + "…"
+}
+
+func isUtf8CharacterStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+func (bot *TelegramBot) forgetLiveMessages(chatId int64) {
+	bot.liveMessagesMutex.Lock()
+	defer bot.liveMessagesMutex.Unlock()
+	for key := range bot.liveMessages {
+		if key.chatId == chatId {
+			delete(bot.liveMessages, key)
+		}
+	}
+}
+
+// Forgets the message if it was not replaced meanwhile, the next update sends a new one
+func (bot *TelegramBot) forgetLiveMessage(key liveMessageKey, message *liveMessage) {
+	bot.liveMessagesMutex.Lock()
+	defer bot.liveMessagesMutex.Unlock()
+	if bot.liveMessages[key] == message {
+		delete(bot.liveMessages, key)
+	}
+}
+
+func (bot *TelegramBot) sendNewLiveMessage(key liveMessageKey, message *liveMessage, text string, imagePath string) {
+	var chattable tgbotapi.Chattable
+	if message.hasPhoto {
+		photo := tgbotapi.NewPhoto(key.chatId, tgbotapi.FilePath(imagePath))
+		photo.Caption = truncateCaption(text)
+		chattable = photo
+	} else {
+		chattable = tgbotapi.NewMessage(key.chatId, text)
+	}
+
+	bot.outgoing.enqueue(&outgoingMessage{
+		chatId:    key.chatId,
+		chattable: chattable,
+		onDelivered: func(sent *tgbotapi.Message, err error) {
+			if err != nil {
+				bot.forgetLiveMessage(key, message)
+				return
+			}
+			bot.liveMessagesMutex.Lock()
+			message.messageId = sent.MessageID
+			bot.liveMessagesMutex.Unlock()
+			if key.robotId == fleetOverviewRobotId {
+				// Needs the pin right in groups, otherwise the overview is only left unpinned
+				bot.send(key.chatId, tgbotapi.PinChatMessageConfig{
+					ChatID:              key.chatId,
+					MessageID:           sent.MessageID,
+					DisableNotification: true,
+				})
+			}
+		},
+	})
+}
+
+func (bot *TelegramBot) editLiveMessage(key liveMessageKey, message *liveMessage, text string, imagePath string) {
+	var chattable tgbotapi.Chattable
+	if message.hasPhoto {
+		photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FilePath(imagePath))
+		photo.Caption = truncateCaption(text)
+		chattable = tgbotapi.EditMessageMediaConfig{
+			BaseEdit: tgbotapi.BaseEdit{ChatID: key.chatId, MessageID: message.messageId},
+			Media:    photo,
+		}
+	} else {
+		chattable = tgbotapi.NewEditMessageText(key.chatId, message.messageId, text)
+	}
+
+	bot.outgoing.enqueue(&outgoingMessage{
+		chatId:    key.chatId,
+		chattable: chattable,
+		// Only the latest edit of a message is worth sending
+		key: key.getQueueKey(),
+		onDelivered: func(_ *tgbotapi.Message, err error) {
+			// e.g. the message was deleted from the chat. A dropped edit leaves the message as it was.
+			if err != nil && !errors.Is(err, errMessageDropped) && !strings.Contains(err.Error(), "message is not modified") {
+				log.Println("Could not edit live message", message.messageId, "of chat", key.chatId, ", sending a new one next time")
+				bot.forgetLiveMessage(key, message)
+			}
+		},
+	})
+}
+
+// Sends the live message of the chat the first time, then edits it
+func (bot *TelegramBot) updateLiveMessage(key liveMessageKey, text string, imagePath string) {
+	_, err := os.Stat(imagePath)
+	hasPhoto := imagePath != "" && err == nil
+
+	bot.liveMessagesMutex.Lock()
+	message, isKnown := bot.liveMessages[key]
+	if isKnown && message.messageId == 0 {
+		// Still being sent, the next update will edit it
+		bot.liveMessagesMutex.Unlock()
+		return
+	}
+	if isKnown && message.hasPhoto == hasPhoto {
+		bot.liveMessagesMutex.Unlock()
+		bot.editLiveMessage(key, message, text, imagePath)
+		return
+	}
+	newMessage := &liveMessage{hasPhoto: hasPhoto}
+	bot.liveMessages[key] = newMessage
+	bot.liveMessagesMutex.Unlock()
+
+	if isKnown {
+		// Replaced by a message of the other kind, e.g. once the first path image is available
+		bot.send(key.chatId, tgbotapi.NewDeleteMessage(key.chatId, message.messageId))
+	}
+	bot.sendNewLiveMessage(key, newMessage, text, imagePath)
+}
+
+func (bot *TelegramBot) getFleetOverview() string {
+	robots := bot.getRobots()
+	return "📌 " + bot.getFleetSummary(robots) + "\n" + bot.getRobotsList(robots) +
+		"\nUpdated at " + time.Now().Format(time.TimeOnly)
+}
+
+func (bot *TelegramBot) updateLiveStatus(robotId int, statusMessage string, imagePath string) {
+	statusMessage += "Updated at " + time.Now().Format(time.TimeOnly)
+	chatIds := bot.getRecipientChatIds()
+	for _, chatId := range chatIds {
+		bot.updateLiveMessage(liveMessageKey{chatId: chatId, robotId: robotId}, statusMessage, imagePath)
+	}
+
+	bot.liveMessagesMutex.Lock()
+	isFleetOverviewDue := time.Since(bot.lastFleetOverviewUpdate) >= FleetOverviewUpdateInterval
+	if isFleetOverviewDue {
+		bot.lastFleetOverviewUpdate = time.Now()
+	}
+	bot.liveMessagesMutex.Unlock()
+	if !isFleetOverviewDue {
+		return
+	}
+	fleetOverview := bot.getFleetOverview()
+	for _, chatId := range chatIds {
+		bot.updateLiveMessage(liveMessageKey{chatId: chatId, robotId: fleetOverviewRobotId}, fleetOverview, "")
+	}
+}
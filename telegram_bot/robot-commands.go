@@ -0,0 +1,106 @@
+package telegram_bot
+
+import (
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	. "paltech.robot/robot"
+)
+
+func formatCommand(command RobotCommand) string {
+	return "Command #" + strconv.Itoa(command.Id) + " " + string(command.Type) + " for robot " + strconv.Itoa(command.RobotId)
+}
+
+// Remembers the chat that issued the command to report its progress there
+func (bot *TelegramBot) issueRobotCommand(incomingMessage *tgbotapi.Message, robotId int, request CommandRequest) {
+	request.IssuedBy = getUserName(incomingMessage.From)
+	command, err := bot.Commands.Enqueue(robotId, request)
+	if err != nil {
+		bot.sendText(incomingMessage.Chat.ID, err.Error())
+		return
+	}
+
+	bot.commandChatsMutex.Lock()
+	bot.commandChatIds[command.Id] = incomingMessage.Chat.ID
+	bot.commandChatsMutex.Unlock()
+
+	message := "⏳ " + formatCommand(command) + " queued, it will be delivered with the next update of the robot"
+	if !bot.isRobotOnline(robotId) {
+		message += "\nThe robot is offline, it will only get it if it comes back before " +
+			command.ExpiresAt.Format(time.TimeOnly)
+	}
+	bot.sendText(incomingMessage.Chat.ID, message)
+}
+
+// For the commands only taking the robot id
+func (bot *TelegramBot) issueSimpleRobotCommand(incomingMessage *tgbotapi.Message, commandType CommandType) {
+	robot, _, isFound := bot.getRobotFromArguments(incomingMessage)
+	if !isFound {
+		return
+	}
+	bot.issueRobotCommand(incomingMessage, robot.Id, CommandRequest{Type: commandType})
+}
+
+func (bot *TelegramBot) respondPause(incomingMessage *tgbotapi.Message) {
+	bot.issueSimpleRobotCommand(incomingMessage, CommandPause)
+}
+
+func (bot *TelegramBot) respondResume(incomingMessage *tgbotapi.Message) {
+	bot.issueSimpleRobotCommand(incomingMessage, CommandResume)
+}
+
+func (bot *TelegramBot) respondAbort(incomingMessage *tgbotapi.Message) {
+	bot.issueSimpleRobotCommand(incomingMessage, CommandAbort)
+}
+
+func (bot *TelegramBot) respondReturnToBase(incomingMessage *tgbotapi.Message) {
+	bot.issueSimpleRobotCommand(incomingMessage, CommandReturnToBase)
+}
+
+func (bot *TelegramBot) respondSkipWaypoint(incomingMessage *tgbotapi.Message) {
+	bot.issueSimpleRobotCommand(incomingMessage, CommandSkipWaypoint)
+}
+
+func (bot *TelegramBot) respondReportInterval(incomingMessage *tgbotapi.Message) {
+	robot, arguments, isFound := bot.getRobotFromArguments(incomingMessage)
+	if !isFound {
+		return
+	}
+	if len(arguments) == 0 {
+		bot.sendText(incomingMessage.Chat.ID, "How often ? Send /"+incomingMessage.Command()+" <robot id> <seconds>")
+		return
+	}
+	seconds, err := strconv.Atoi(arguments[0])
+	if err != nil {
+		bot.sendText(incomingMessage.Chat.ID, "I'm having trouble reading "+arguments[0]+" as a number of seconds")
+		return
+	}
+	bot.issueRobotCommand(incomingMessage, robot.Id, CommandRequest{
+		Type:                  CommandSetReportInterval,
+		ReportIntervalSeconds: seconds,
+	})
+}
+
+func (bot *TelegramBot) SendCommandUpdate(command RobotCommand) {
+	bot.commandChatsMutex.Lock()
+	chatId, isFromTelegram := bot.commandChatIds[command.Id]
+	if command.IsFinished() {
+		delete(bot.commandChatIds, command.Id)
+	}
+	bot.commandChatsMutex.Unlock()
+	if !isFromTelegram {
+		return
+	}
+
+	switch command.State {
+	case CommandStateDelivered:
+		bot.sendText(chatId, "📨 "+formatCommand(command)+" delivered")
+	case CommandStateAcknowledged:
+		bot.sendText(chatId, "✅ "+formatCommand(command)+" executed")
+	case CommandStateFailed:
+		bot.sendText(chatId, "❌ "+formatCommand(command)+" failed : "+command.Error)
+	case CommandStateExpired:
+		bot.sendText(chatId, "⌛ "+formatCommand(command)+" expired : "+command.Error)
+	}
+}
@@ -1,10 +1,12 @@
 package telegram_bot
 
 import (
-	"errors"
+	"encoding/json"
 	"log"
+	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	. "paltech.robot/robot"
@@ -12,74 +14,163 @@ import (
 
 type TelegramBot struct {
 	apiBot *tgbotapi.BotAPI
-	recipientsChatIdSet map[int64]bool
+	// Users whose access let them subscribe each chat, the chat itself when it was granted access directly
+	recipientsSubscribers map[int64]map[int64]bool
 	recipientsMutex sync.Mutex
 	Robots *[]*Robot
 	RobotsMutex *sync.Mutex
+	Alerts *AlertManager
+	EscalationChatIds []int64
+	Access *AccessControl
+	Commands *CommandQueue
+	// Chat that issued each pending robot command
+	commandChatIds map[int]int64
+	commandChatsMutex sync.Mutex
+	outgoing *deliveryQueue
+	// Edit the live status messages instead of sending new periodic updates
+	LiveStatus bool
+	liveMessages map[liveMessageKey]*liveMessage
+	liveMessagesMutex sync.Mutex
+	lastFleetOverviewUpdate time.Time
+	// Used to draw the fleet map of the digests
+	MapOptions *StaticMapOptions
+	// Path images sent with the status messages
+	PathImages *PathImageStore
+	// Area worked reported in the mission summaries, nil leaves it out
+	Coverage *CoverageCache
+	// One fleet digest per interval replaces the periodic updates of every robot, 0 disables it
+	DigestInterval time.Duration
+	// Only the hour and minute are used, the zero time disables the daily summary
+	DailySummaryTime time.Time
 }
 
-func NewTelegramBot(apiKey string) (bot *TelegramBot, err error) {
+func NewTelegramBot(apiKey string, access *AccessControl) (bot *TelegramBot, err error) {
 	bot = new(TelegramBot)
+	bot.Access = access
 	bot.apiBot, err = tgbotapi.NewBotAPI(apiKey)
 	log.Printf("Authorized on account %s", *(&bot.apiBot.Self.UserName))
-	bot.recipientsChatIdSet = make(map[int64]bool)
+	bot.recipientsSubscribers = make(map[int64]map[int64]bool)
+	bot.commandChatIds = make(map[int]int64)
+	bot.outgoing = newDeliveryQueue(bot.deliver, bot.removeUnreachableRecipient)
+	bot.liveMessages = make(map[liveMessageKey]*liveMessage)
 	return bot, err
 }
 
+// Pins and deletions do not return a message, only true
+func (bot *TelegramBot) deliver(chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var sent tgbotapi.Message
+	response, err := bot.apiBot.Request(chattable)
+	if err != nil {
+		return sent, err
+	}
+	json.Unmarshal(response.Result, &sent)
+	return sent, nil
+}
+
+// Empty until an image of the robot path was saved
+func (bot *TelegramBot) getPathImagePath(robot *Robot) string {
+	if bot.PathImages == nil {
+		return ""
+	}
+	image, _ := bot.PathImages.GetLatest(robot.Id)
+	return image.Path
+}
+
 func (bot *TelegramBot) getUpdateMessageAndImagePathForRobot(id int) (string, string, error) {
-	bot.RobotsMutex.Lock()
-	if id >= len(*bot.Robots) {
-		bot.RobotsMutex.Unlock()
-		return "", "", errors.New("Hum, I can't find bot " + strconv.Itoa(id) + ", are you sure it was created ?")
+	robot, err := bot.getRobot(id)
+	if err != nil {
+		return "", "", err
 	}
 
-	robot := (*bot.Robots)[id]
 	robotId := strconv.Itoa(robot.Id)
-	statusHistoryLength := strconv.Itoa(len(robot.StatusHistory))
-	latestStatus := (*bot.Robots)[id].GetLatestStatus()
-	bot.RobotsMutex.Unlock()
+	latestStatus := robot.GetLatestStatus()
+	latestKinematics := robot.GetLatestKinematics()
 
 	message := "Status of robot " + robotId + " :\n"
 	message += " - Completion : " + strconv.Itoa(latestStatus.WaypointsReached) + "/" 
 	message += strconv.Itoa(latestStatus.WaypointsTotal) + " waypoints reached\n"
 	message += " - Distance covered : " + strconv.FormatFloat(latestStatus.DistanceCovered, 'f', 1, 64) + "m\n"
+	heading := latestStatus.GetHeadingDegrees()
+	message += " - Heading : " + strconv.FormatFloat(heading, 'f', 0, 64) + "° (" + GetCompassPoint(heading) + ")"
+	message += ", turning at " + strconv.FormatFloat(latestStatus.GetTurnRateDegrees(), 'f', 1, 64) + "°/s\n"
+	if latestStatus.HasTelemetry() {
+		message += latestStatus.Telemetry.ToString()
+	}
+	if latestKinematics != nil {
+		message += " - GPS speed : " + strconv.FormatFloat(latestKinematics.GPSSpeed, 'f', 2, 64) + "m/s\n"
+		if latestKinematics.HasAnomalies() {
+			message += " - Anomalies : " + formatAnomalies(latestKinematics.Anomalies) + "\n"
+		}
+	}
 
-	filepath := "pathImages/path-" + robotId + "-" + statusHistoryLength + ".png"
+	return message, bot.getPathImagePath(&robot), nil
+}
 
-	return message, filepath, nil
+func formatAnomalies(anomalies []AnomalyType) string {
+	str := ""
+	for i, anomaly := range anomalies {
+		str += string(anomaly)
+		if i < len(anomalies) - 1 {
+			str += ", "
+		}
+	}
+	return str
 }
 
-func (bot *TelegramBot) sendText(chatId int64, text string) {
-	textMessage := tgbotapi.NewMessage(chatId, text)
-	if _, err := bot.apiBot.Send(textMessage); err != nil {
-		log.Panic(err)
+func formatIdleReason(reason IdleReason) string {
+	switch reason {
+	case IdleReasonNoDisplacement:
+		return "It has not moved recently"
+	case IdleReasonNoWaypointProgress:
+		return "It has not reached any waypoint recently"
+	case IdleReasonNoSpeed:
+		return "Its speed has been close to zero"
 	}
+	return string(reason)
+}
+
+// Messages are queued and sent within the Telegram rate limits, delivery failures are only logged
+func (bot *TelegramBot) send(chatId int64, chattable tgbotapi.Chattable) {
+	bot.outgoing.enqueue(&outgoingMessage{chatId: chatId, chattable: chattable})
+}
+
+func (bot *TelegramBot) sendText(chatId int64, text string) {
+	bot.send(chatId, tgbotapi.NewMessage(chatId, text))
 }
 
 func (bot *TelegramBot) sendImage(chatId int64, imagePath string) {
-	imageMessage := tgbotapi.NewPhoto(chatId, tgbotapi.FilePath(imagePath))
-	if _, err := bot.apiBot.Send(imageMessage); err != nil {
-		log.Panic(err)
+	// Path images are not generated for every update, e.g. during load tests
+	if _, err := os.Stat(imagePath); err != nil {
+		log.Println("No path image to send at", imagePath)
+		return
 	}
+	bot.send(chatId, tgbotapi.NewPhoto(chatId, tgbotapi.FilePath(imagePath)))
 }
 
-func (bot *TelegramBot) respondHelp(incomingMessage *tgbotapi.Message) {
-	bot.sendText(
-		incomingMessage.Chat.ID,
-		"Send /status-<bot id> to get an immediate status update on bot with id <bot id>",
-	)
+func (bot *TelegramBot) getRecipientChatIds() []int64 {
+	bot.recipientsMutex.Lock()
+	defer bot.recipientsMutex.Unlock()
+	chatIds := make([]int64, 0, len(bot.recipientsSubscribers))
+	for chatId := range bot.recipientsSubscribers {
+		chatIds = append(chatIds, chatId)
+	}
+	return chatIds
+}
+
+func (bot *TelegramBot) broadcastText(text string) {
+	for _, recipientChatId := range bot.getRecipientChatIds() {
+		bot.sendText(recipientChatId, text)
+	}
 }
 
 func (bot *TelegramBot) respondStatusUpdate(incomingMessage *tgbotapi.Message) {
-	args := incomingMessage.CommandArguments()
-	chatId := incomingMessage.Chat.ID
-	robotId, err := strconv.Atoi(args)
-	if err != nil {
-		bot.sendText(chatId, "I'm having trouble reading " + args + " as an integer")
+	robot, _, isFound := bot.getRobotFromArguments(incomingMessage)
+	if !isFound {
 		return
 	}
+	chatId := incomingMessage.Chat.ID
 
-	message, imagepath, err := bot.getUpdateMessageAndImagePathForRobot(robotId)
+	message, imagepath, err := bot.getUpdateMessageAndImagePathForRobot(robot.Id)
 	if err != nil {
 		bot.sendText(chatId, err.Error())
 		return
@@ -90,21 +181,72 @@ func (bot *TelegramBot) respondStatusUpdate(incomingMessage *tgbotapi.Message) {
 }
 
 
-func (bot *TelegramBot) registerRecipient(incomingMessage *tgbotapi.Message) {
+func (bot *TelegramBot) addRecipient(chatId int64, subscriberId int64) {
 	bot.recipientsMutex.Lock()
-	bot.recipientsChatIdSet[incomingMessage.Chat.ID] = true
+	if bot.recipientsSubscribers[chatId] == nil {
+		bot.recipientsSubscribers[chatId] = make(map[int64]bool)
+	}
+	bot.recipientsSubscribers[chatId][subscriberId] = true
 	bot.recipientsMutex.Unlock()
+}
+
+func (bot *TelegramBot) removeRecipient(chatId int64) {
+	bot.recipientsMutex.Lock()
+	delete(bot.recipientsSubscribers, chatId)
+	bot.recipientsMutex.Unlock()
+	bot.forgetLiveMessages(chatId)
+}
+
+// Unsubscribes the chats none of whose subscribers is allowed anymore, e.g. the groups a revoked user subscribed.
+// Returns the unsubscribed chats.
+func (bot *TelegramBot) removeRecipientsWithoutAccess() []int64 {
+	removed := make([]int64, 0)
+	bot.recipientsMutex.Lock()
+	for chatId, subscriberIds := range bot.recipientsSubscribers {
+		for subscriberId := range subscriberIds {
+			if bot.Access.GetRole(subscriberId, chatId) == RoleNone {
+				delete(subscriberIds, subscriberId)
+			}
+		}
+		if len(subscriberIds) == 0 {
+			delete(bot.recipientsSubscribers, chatId)
+			removed = append(removed, chatId)
+		}
+	}
+	bot.recipientsMutex.Unlock()
+	for _, chatId := range removed {
+		bot.forgetLiveMessages(chatId)
+	}
+	return removed
+}
+
+// Chats that blocked the bot or were deleted stop receiving updates, they can /start again
+func (bot *TelegramBot) removeUnreachableRecipient(chatId int64) {
+	log.Println("Unsubscribing unreachable chat", chatId)
+	bot.removeRecipient(chatId)
+}
+
+// Chats that are not allowed yet send a join request to the admins instead
+func (bot *TelegramBot) registerRecipient(incomingMessage *tgbotapi.Message) {
+	if bot.getRoleForMessage(incomingMessage) == RoleNone {
+		bot.requestAccess(incomingMessage)
+		return
+	}
+	// Channel posts have no sender, the chat itself is then allowed
+	subscriberId := incomingMessage.Chat.ID
+	if incomingMessage.From != nil {
+		subscriberId = incomingMessage.From.ID
+	}
+	bot.addRecipient(incomingMessage.Chat.ID, subscriberId)
 	bot.sendText(
 		incomingMessage.Chat.ID, 
 		"You registered successfully. You will now receive periodic updates.\n" +
-		"You can send /status-<bot id> to get an immediate status update on bot <bot id>",
+		"You can send /status <robot id> to get an immediate status update on a robot, or /help to list the commands",
 	)
 }
 
 func (bot *TelegramBot) unregisterRecipient(incomingMessage *tgbotapi.Message) {
-	bot.recipientsMutex.Lock()
-	delete(bot.recipientsChatIdSet, incomingMessage.Chat.ID)
-	bot.recipientsMutex.Unlock()
+	bot.removeRecipient(incomingMessage.Chat.ID)
 	bot.sendText(incomingMessage.Chat.ID, "You unregistered successfully. You will not receive any more updates.")
 }
 
@@ -115,6 +257,11 @@ func (bot *TelegramBot) processUpdates() {
     updates := bot.apiBot.GetUpdatesChan(u)
 
     for update := range updates {
+        if update.CallbackQuery != nil {
+			go bot.respondCallbackQuery(update.CallbackQuery)
+			continue
+        }
+
         if update.Message == nil {
             continue
         }
@@ -123,20 +270,16 @@ func (bot *TelegramBot) processUpdates() {
             continue
         }
 
-        switch update.Message.Command() {
-        case "status":
-			go bot.respondStatusUpdate(update.Message)
-		case "start":
-			go bot.registerRecipient(update.Message)
-		case "stop":
-
-        default:
-            go bot.respondHelp(update.Message)
-        }
+        go bot.respondCommand(update.Message)
     }
 }
 
 func (bot *TelegramBot) ListenAndServe() {
+	bot.registerCommands()
+	go bot.outgoing.deliverMessages()
+	if bot.IsDigestEnabled() || !bot.DailySummaryTime.IsZero() {
+		go bot.watchDigests()
+	}
 	go bot.processUpdates()
 }
 
@@ -145,29 +288,49 @@ func (bot *TelegramBot) ListenAndServe() {
 func (bot *TelegramBot) SendPeriodicUpdateForRobot(robotId int, isRobotBackOnlineMessage bool) {
 	message, imagepath, err := bot.getUpdateMessageAndImagePathForRobot(robotId)
 	if err != nil {
-		log.Panic(err)
+		log.Println("Could not prepare the periodic update of robot", robotId, ":", err)
 		return
 	}
 
+	// Live status messages and digests replace the new messages sent for every robot
+	if bot.LiveStatus || bot.IsDigestEnabled() {
+		if isRobotBackOnlineMessage {
+			bot.broadcastText("Robot " + strconv.Itoa(robotId) + " came back online !")
+		}
+		if bot.LiveStatus {
+			bot.updateLiveStatus(robotId, message, imagepath)
+		}
+		return
+	}
 	if isRobotBackOnlineMessage {
 		message = "Robot " + strconv.Itoa(robotId) + " came back online !\n" + message
 	}
 
-	bot.recipientsMutex.Lock()
-	for recipientChatId := range bot.recipientsChatIdSet {
+	for _, recipientChatId := range bot.getRecipientChatIds() {
 		bot.sendText(recipientChatId, message)
 		bot.sendImage(recipientChatId, imagepath)
 	}
-	bot.recipientsMutex.Unlock()
 }
 
-func (bot *TelegramBot) SendTimeoutMessage(robotId int) {
-	message := "Robot " + strconv.Itoa(robotId) + " timed out !"
+func (bot *TelegramBot) SendAnomalyMessage(robotId int, kinematics *Kinematics) {
+	message := "Robot " + strconv.Itoa(robotId) + " reported suspicious odometry : " + formatAnomalies(kinematics.Anomalies) + "\n"
+	message += " - GPS distance : " + strconv.FormatFloat(kinematics.GPSDistance, 'f', 1, 64) + "m"
+	message += " in " + strconv.FormatFloat(kinematics.ElapsedSeconds, 'f', 0, 64) + "s\n"
+	message += " - Odometer distance : " + strconv.FormatFloat(kinematics.OdometerDistance, 'f', 1, 64) + "m\n"
+	message += " - GPS speed : " + strconv.FormatFloat(kinematics.GPSSpeed, 'f', 1, 64) + "m/s"
+	message += ", reported speed : " + strconv.FormatFloat(kinematics.OdometerSpeed, 'f', 1, 64) + "m/s\n"
+	bot.broadcastText(message)
+}
 
-	// TODO Maybe move to a broadcastRecipients function ?
-	bot.recipientsMutex.Lock()
-	for recipientChatId := range bot.recipientsChatIdSet {
-		bot.sendText(recipientChatId, message)
+func (bot *TelegramBot) SendIdleMessage(robotId int, reasons []IdleReason) {
+	message := "Robot " + strconv.Itoa(robotId) + " seems stuck :\n"
+	for _, reason := range reasons {
+		message += " - " + formatIdleReason(reason) + "\n"
 	}
-	bot.recipientsMutex.Unlock()
+	bot.broadcastText(message)
+}
+
+func (bot *TelegramBot) SendIdleClearedMessage(robotId int) {
+	message := "Robot " + strconv.Itoa(robotId) + " is making progress again"
+	bot.broadcastText(message)
 }
\ No newline at end of file